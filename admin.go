@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/ArrayOfLilly/chirpy/internal/auth"
+)
+
+// middlewareAdminAuth only lets requests through that carry the admin API
+// key as "Authorization: ApiKey <key>". Without ADMIN_API_KEY configured
+// the wrapped endpoints are disabled.
+func (cfg *apiConfig) middlewareAdminAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if cfg.adminApiKey == "" {
+			respondWithError(w, http.StatusForbidden, "Admin API is disabled")
+			return
+		}
+
+		apiKey, err := auth.GetApiKey(r.Header)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't find API key")
+			return
+		}
+		if !auth.CheckApiKey(cfg.adminApiKey, apiKey) {
+			respondWithError(w, http.StatusUnauthorized, "Unauthorized user")
+			return
+		}
+
+		next(w, r)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/ArrayOfLilly/chirpy/internal/database"
+)
+
+// runCommand runs an admin subcommand against the store and reports
+// whether args named one.
+func runCommand(db database.Store, backups database.Backups, args []string) (bool, error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+
+	switch args[0] {
+	case "backup":
+		info, err := backups.Write(db)
+		if err != nil {
+			return true, err
+		}
+		fmt.Printf("Wrote backup %s (sha256 %s)\n", info.Name, info.Checksum)
+		return true, nil
+
+	case "backups":
+		backups, err := backups.List()
+		if err != nil {
+			return true, err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tCREATED\tSIZE\tSHA256")
+		for _, backup := range backups {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", backup.Name, backup.CreatedAt.Format("2006-01-02 15:04:05"), backup.Size, backup.Checksum)
+		}
+		return true, tw.Flush()
+
+	case "restore":
+		if len(args) != 2 {
+			return true, errors.New("usage: restore <backup name>")
+		}
+		info, err := backups.Restore(db, args[1])
+		if err != nil {
+			return true, err
+		}
+		fmt.Printf("Restored backup %s taken at %s\n", info.Name, info.CreatedAt.Format("2006-01-02 15:04:05"))
+		return true, nil
+
+	case "rotate-key":
+		return true, rotateKey(db, backups)
+
+	case "migrate":
+		return true, migrateSchema(db, args[1:])
+
+	case "rebuild-timelines":
+		total, err := db.RebuildTimelines()
+		if err != nil {
+			return true, err
+		}
+		fmt.Printf("Rebuilt the home timelines with %d entries\n", total)
+		return true, nil
+
+	default:
+		return true, fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// readOnlyCommand reports whether args name a subcommand that only reads
+// the database. Those open the JSON store read-only, so they can run while
+// a server holds its writer lock.
+func readOnlyCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	switch args[0] {
+	case "backup", "backups":
+		return true
+	case "migrate":
+		// Invalid arguments fail the command; they must not upgrade the
+		// database on the way.
+		dryRun, err := parseMigrateArgs(args[1:])
+		return err != nil || dryRun
+	default:
+		return false
+	}
+}
+
+// rotateKey re-encrypts the JSON database and the backups with the key in
+// DB_ENCRYPTION_NEW_KEY. DB_ENCRYPTION_KEY, if set, must be the current key.
+func rotateKey(db database.Store, backups database.Backups) error {
+	jsonDB, ok := db.(*database.DB)
+	if !ok {
+		return errors.New("rotate-key requires -db json")
+	}
+	newCipher, err := cipherFromEnv("DB_ENCRYPTION_NEW_KEY")
+	if err != nil {
+		return err
+	}
+	if newCipher == nil {
+		return errors.New("DB_ENCRYPTION_NEW_KEY environment variable is not set")
+	}
+
+	err = jsonDB.RotateKey(newCipher)
+	if err != nil {
+		return err
+	}
+	rotated, err := backups.RotateKey(newCipher)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Re-encrypted the database and %d backups; set DB_ENCRYPTION_KEY to the new key\n", rotated)
+	return nil
+}
+
+func parseMigrateArgs(args []string) (dryRun bool, err error) {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	// The error is returned and reported by the caller.
+	fs.SetOutput(io.Discard)
+	fs.BoolVar(&dryRun, "dry-run", false, "report the upgrades without writing them")
+	err = fs.Parse(args)
+	if err != nil {
+		return false, err
+	}
+	if fs.NArg() > 0 {
+		return false, errors.New("usage: migrate [-dry-run]")
+	}
+	return dryRun, nil
+}
+
+// migrateSchema reports the schema upgrades of the JSON database. Opening
+// the database for writing has already applied and saved them; with
+// -dry-run it was opened read-only, so nothing was written.
+func migrateSchema(db database.Store, args []string) error {
+	dryRun, err := parseMigrateArgs(args)
+	if err != nil {
+		return err
+	}
+	jsonDB, ok := db.(*database.DB)
+	if !ok {
+		return errors.New("migrate requires -db json; SQLite migrations are applied on startup")
+	}
+
+	report := jsonDB.UpgradeReport()
+	if len(report.Steps) == 0 {
+		fmt.Printf("Database is at schema version %d; nothing to upgrade\n", report.ToVersion)
+		return nil
+	}
+	if dryRun {
+		fmt.Printf("Would upgrade from schema version %d to %d:\n", report.FromVersion, report.ToVersion)
+	} else {
+		fmt.Printf("Upgraded from schema version %d to %d:\n", report.FromVersion, report.ToVersion)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, step := range report.Steps {
+		fmt.Fprintf(tw, "  %d\t%s\t%d changes\n", step.Version, step.Description, step.Changes)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+const (
+	defaultChirpPageSize = 20
+	maxChirpPageSize     = 100
+)
+
+// chirpCursor is where the next page of chirps starts. Clients get it
+// base64-encoded as next_cursor and must treat it as opaque.
+type chirpCursor struct {
+	AfterID    int  `json:"after_id"`
+	Descending bool `json:"desc"`
+}
+
+func encodeChirpCursor(cursor chirpCursor) string {
+	// A struct of an int and a bool always marshals.
+	dat, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(dat)
+}
+
+func decodeChirpCursor(s string) (chirpCursor, error) {
+	cursor := chirpCursor{}
+	dat, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor, err
+	}
+	err = json.Unmarshal(dat, &cursor)
+	if err != nil {
+		return cursor, err
+	}
+	if cursor.AfterID < 1 {
+		return cursor, errors.New("cursor has no position")
+	}
+	return cursor, nil
+}
+
+// parsePageParams reads the limit and cursor query parameters of a paged
+// listing sorted in the given direction. It returns the default page size
+// without a limit, and an afterID of 0 without a cursor. The errors are
+// meant for the client.
+func parsePageParams(params url.Values, descending bool) (limit, afterID int, err error) {
+	limit = defaultChirpPageSize
+	if params.Has("limit") {
+		limit, err = strconv.Atoi(params.Get("limit"))
+		if err != nil || limit < 1 || limit > maxChirpPageSize {
+			return 0, 0, fmt.Errorf("Limit must be between 1 and %d", maxChirpPageSize)
+		}
+	}
+	if cursorStr := params.Get("cursor"); cursorStr != "" {
+		cursor, err := decodeChirpCursor(cursorStr)
+		if err != nil {
+			return 0, 0, errors.New("Invalid cursor")
+		}
+		if cursor.Descending != descending {
+			return 0, 0, errors.New("Cursor doesn't match the sort order")
+		}
+		afterID = cursor.AfterID
+	}
+	return limit, afterID, nil
+}
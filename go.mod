@@ -7,3 +7,5 @@ require golang.org/x/crypto v0.23.0
 require github.com/joho/godotenv v1.5.1
 
 require github.com/golang-jwt/jwt/v5 v5.2.1
+
+require github.com/mattn/go-sqlite3 v1.14.22
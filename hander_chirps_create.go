@@ -6,19 +6,53 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/ArrayOfLilly/chirpy/internal/auth"
+	"github.com/ArrayOfLilly/chirpy/internal/database"
 )
 
 type Chirp struct {
 	ID   int    `json:"id"`
 	Body string `json:"body"`
 	AuthorID int `json:"author_id"`
+	InReplyTo int `json:"in_reply_to,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	EditedAt *time.Time `json:"edited_at,omitempty"`
+	RechirpOf int `json:"rechirp_of,omitempty"`
+	RechirpedChirp *ChirpRef `json:"rechirped_chirp,omitempty"`
+	QuotedChirpID int `json:"quoted_chirp_id,omitempty"`
+	QuotedChirp *ChirpRef `json:"quoted_chirp,omitempty"`
+	Mentions []Mention `json:"mentions,omitempty"`
+	LikeCount int `json:"like_count"`
+	LikedByMe bool `json:"liked_by_me"`
+}
+
+// chirpFromDB converts a stored chirp to its API representation. The
+// chirps it refers to and its like count are filled in by expandChirps.
+func chirpFromDB(dbChirp database.Chirp) Chirp {
+	return Chirp{
+		ID:        dbChirp.ID,
+		Body:      dbChirp.Body,
+		AuthorID:  dbChirp.AuthorID,
+		InReplyTo: dbChirp.InReplyTo,
+		RechirpOf: dbChirp.RechirpOf,
+		QuotedChirpID: dbChirp.QuotedChirpID,
+		Mentions: mentionsFromDB(dbChirp.Mentions),
+		CreatedAt: dbChirp.CreatedAt,
+		UpdatedAt: dbChirp.UpdatedAt,
+		EditedAt:  dbChirp.EditedAt,
+	}
 }
 
 func (cfg *apiConfig) handlerChirpsCreate(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
 		Body string `json:"body"`
+		// InReplyTo is the ID of the chirp this one replies to, if any.
+		InReplyTo int `json:"in_reply_to"`
+		// QuotedChirpID is the ID of the chirp this one quotes, if any.
+		QuotedChirpID int `json:"quoted_chirp_id"`
 	}
 
 	token, err := auth.GetBearerToken(r.Header)
@@ -52,18 +86,43 @@ func (cfg *apiConfig) handlerChirpsCreate(w http.ResponseWriter, r *http.Request
 		respondWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	mentions, err := cfg.findMentions(cleaned)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't look up mentions")
+		return
+	}
 
-	chirp, err := cfg.DB.CreateChirp(cleaned, userID)
+	chirp, err := cfg.DB.CreateChirp(database.NewChirp{
+		Body:      cleaned,
+		AuthorID:  userID,
+		InReplyTo: params.InReplyTo,
+		QuotedChirpID: params.QuotedChirpID,
+		Mentions: mentions,
+	})
+	if errors.Is(err, database.ErrUnauthorized) {
+		respondWithError(w, http.StatusUnauthorized, "User doesn't exist")
+		return
+	}
+	if errors.Is(err, database.ErrInvalidReply) {
+		respondWithError(w, http.StatusBadRequest, "in_reply_to must be the ID of an existing chirp")
+		return
+	}
+	if errors.Is(err, database.ErrInvalidQuote) {
+		respondWithError(w, http.StatusBadRequest, "quoted_chirp_id must be the ID of an existing chirp")
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create chirp")
 		return
 	}
 
-	respondWithJSON(w, http.StatusCreated, Chirp{
-		ID:   chirp.ID,
-		Body: chirp.Body,
-		AuthorID: chirp.AuthorID,
-	})
+	resp := chirpFromDB(chirp)
+	err = cfg.expandChirps(userID, &resp)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chirp details")
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, resp)
 }
 
 func validateChirp(body string) (string, error) {
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+
+	"github.com/ArrayOfLilly/chirpy/internal/database"
+)
+
+func (cfg *apiConfig) handlerBackupsCreate(w http.ResponseWriter, r *http.Request) {
+	info, err := cfg.backups.Write(cfg.DB)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't write backup")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, info)
+}
+
+func (cfg *apiConfig) handlerBackupsList(w http.ResponseWriter, r *http.Request) {
+	backups, err := cfg.backups.List()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't list backups")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, backups)
+}
+
+func (cfg *apiConfig) handlerBackupsRestore(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	info, err := cfg.backups.Restore(cfg.DB, name)
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrInvalidBackupName), errors.Is(err, os.ErrNotExist):
+			respondWithError(w, http.StatusNotFound, "Couldn't find backup")
+		case errors.Is(err, database.ErrChecksumMismatch),
+			errors.Is(err, database.ErrCorrupt),
+			errors.Is(err, database.ErrInvalidSnapshot):
+			respondWithError(w, http.StatusUnprocessableEntity, err.Error())
+		default:
+			respondWithError(w, http.StatusInternalServerError, "Couldn't restore backup")
+		}
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, info)
+}
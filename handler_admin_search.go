@@ -0,0 +1,21 @@
+package main
+
+import (
+	"net/http"
+)
+
+// handlerSearchReindex rebuilds the full-text search index from scratch.
+func (cfg *apiConfig) handlerSearchReindex(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Indexed int `json:"indexed"`
+	}
+
+	indexed, err := cfg.DB.RebuildSearchIndex()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't rebuild search index")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response{
+		Indexed: indexed,
+	})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ArrayOfLilly/chirpy/internal/database"
+)
+
+type trashedChirp struct {
+	Chirp
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+type trashedUser struct {
+	User
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+func (cfg *apiConfig) handlerTrashList(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Chirps []trashedChirp `json:"chirps"`
+		Users  []trashedUser  `json:"users"`
+	}
+
+	trash, err := cfg.DB.ListTrash()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't list trash")
+		return
+	}
+
+	resp := response{
+		Chirps: make([]trashedChirp, 0, len(trash.Chirps)),
+		Users:  make([]trashedUser, 0, len(trash.Users)),
+	}
+	for _, chirp := range trash.Chirps {
+		resp.Chirps = append(resp.Chirps, trashedChirp{
+			Chirp:     chirpFromDB(chirp),
+			DeletedAt: *chirp.DeletedAt,
+		})
+	}
+	for _, user := range trash.Users {
+		resp.Users = append(resp.Users, trashedUser{
+			User:      userFromDB(user),
+			DeletedAt: *user.DeletedAt,
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func (cfg *apiConfig) handlerTrashRestoreChirp(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := strconv.Atoi(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	err = cfg.DB.RestoreChirp(chirpID)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Couldn't find chirp in the trash")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't restore chirp")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerTrashRestoreUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	err = cfg.DB.RestoreUser(userID)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user in the trash")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't restore user")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
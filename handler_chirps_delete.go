@@ -1,10 +1,12 @@
 package main
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
 	"github.com/ArrayOfLilly/chirpy/internal/auth"
+	"github.com/ArrayOfLilly/chirpy/internal/database"
 )
 
 func (cfg *apiConfig) handlerChirpsDelete(w http.ResponseWriter, r *http.Request) {
@@ -42,6 +44,10 @@ func (cfg *apiConfig) handlerChirpsDelete(w http.ResponseWriter, r *http.Request
 	}
 
 	err = cfg.DB.DeleteChirp(chirpID)
+	if errors.Is(err, database.ErrUnauthorized) {
+		respondWithError(w, http.StatusUnauthorized, "User doesn't exist")
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't delete chirp")
 		return
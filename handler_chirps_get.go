@@ -1,9 +1,15 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
-	"sort"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ArrayOfLilly/chirpy/internal/database"
 )
 
 func (cfg *apiConfig) handlerChirpGet(w http.ResponseWriter, r *http.Request) {
@@ -13,62 +19,153 @@ func (cfg *apiConfig) handlerChirpGet(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
 		return
 	}
+	viewerID := cfg.viewerID(r)
 	dbChirp, err := cfg.DB.GetChirp(chirpId)
 	if err != nil {
 		respondWithError(w, http.StatusNotFound, "Couldn't get chirp")
 		return
 	}
-	
-	respondWithJSON(w, http.StatusOK, Chirp{
-		ID:   dbChirp.ID,
-		Body: dbChirp.Body,
-		AuthorID: dbChirp.AuthorID,
-	})
+
+	chirp := chirpFromDB(dbChirp)
+	err = cfg.expandChirps(viewerID, &chirp)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chirp details")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, chirp)
 }
 
+// handlerChirpsRetrieve lists chirps, optionally filtered, sorted by ID.
+// With a limit or cursor parameter the list is paginated and wrapped in an
+// object carrying the next_cursor to continue from; without either every
+// chirp is returned as a plain array.
 func (cfg *apiConfig) handlerChirpsRetrieve(w http.ResponseWriter, r *http.Request) {
-	dbChirps, err := cfg.DB.GetChirps()
+	params := r.URL.Query()
+	query := database.ChirpQuery{
+		Descending: params.Get("sort") == "desc",
+	}
+
+	err := parseChirpFilters(params, &query)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	viewerID := cfg.viewerID(r)
+
+	paginated := params.Has("limit") || params.Has("cursor")
+	if paginated {
+		query.Limit, query.AfterID, err = parsePageParams(params, query.Descending)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	page, err := cfg.DB.ListChirps(query)
+	if errors.Is(err, database.ErrInvalidQuery) {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chirps")
 		return
 	}
 
-	chirps := []Chirp{}
-	for _, dbChirp := range dbChirps {
-		chirps = append(chirps, Chirp{
-			ID:   dbChirp.ID,
-			Body: dbChirp.Body,
-			AuthorID: dbChirp.AuthorID,
+	chirps := make([]Chirp, 0, len(page.Chirps))
+	for _, dbChirp := range page.Chirps {
+		chirps = append(chirps, chirpFromDB(dbChirp))
+	}
+	err = cfg.expandChirps(viewerID, chirpPointers(chirps)...)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chirp details")
+		return
+	}
+	if !paginated {
+		respondWithJSON(w, http.StatusOK, chirps)
+		return
+	}
+
+	type response struct {
+		Chirps     []Chirp `json:"chirps"`
+		NextCursor string  `json:"next_cursor,omitempty"`
+	}
+	resp := response{
+		Chirps: chirps,
+	}
+	if page.More {
+		resp.NextCursor = encodeChirpCursor(chirpCursor{
+			AfterID:    chirps[len(chirps)-1].ID,
+			Descending: query.Descending,
 		})
 	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
 
-	authorIdStr := r.URL.Query().Get("author_id")
-	if authorIdStr != ""{
-		authorId, err := strconv.Atoi(authorIdStr)
-		if err == nil {
-			for _, dbChirp := range dbChirps {
-				if dbChirp.AuthorID == authorId {
-					chirps = []Chirp{}
-					chirps = append(chirps, Chirp{
-						ID:   dbChirp.ID,
-						Body: dbChirp.Body,
-						AuthorID: dbChirp.AuthorID,
-					})
-				}
+// parseChirpFilters fills the filters of query from the request's query
+// parameters:
+//
+//   - author_id: chirps by these authors; repeat it or separate IDs by commas
+//   - since_id, max_id: chirps with an ID above since_id and up to max_id
+//   - since, until: chirps created at or after since and before until, in
+//     RFC 3339
+//   - contains: chirps whose body contains this text, matching case
+//
+// Contradicting values are left to the query's own validation.
+func parseChirpFilters(params url.Values, query *database.ChirpQuery) error {
+	for _, value := range params["author_id"] {
+		for _, idStr := range strings.Split(value, ",") {
+			authorId, err := strconv.Atoi(strings.TrimSpace(idStr))
+			if err != nil || authorId < 1 {
+				return fmt.Errorf("Invalid author ID %q", idStr)
 			}
+			query.AuthorIDs = append(query.AuthorIDs, authorId)
 		}
 	}
 
-	sortDirection := r.URL.Query().Get("sort")
-	if sortDirection == "desc" {
-		sort.Slice(chirps, func(i, j int) bool {
-			return chirps[i].ID > chirps[j].ID
-		})
-	} else {
-		sort.Slice(chirps, func(i, j int) bool {
-			return chirps[i].ID < chirps[j].ID
-		})
+	// The parameters are checked in a fixed order, so a request with
+	// several bad ones always gets the same error.
+	idParams := []struct {
+		name string
+		id   *int
+	}{
+		{"since_id", &query.SinceID},
+		{"max_id", &query.MaxID},
+	}
+	for _, param := range idParams {
+		if !params.Has(param.name) {
+			continue
+		}
+		value, err := strconv.Atoi(params.Get(param.name))
+		if err != nil || value < 1 {
+			return fmt.Errorf("Invalid %s", param.name)
+		}
+		*param.id = value
+	}
+
+	timeParams := []struct {
+		name string
+		t    *time.Time
+	}{
+		{"since", &query.Since},
+		{"until", &query.Until},
+	}
+	for _, param := range timeParams {
+		if !params.Has(param.name) {
+			continue
+		}
+		value, err := time.Parse(time.RFC3339, params.Get(param.name))
+		if err != nil {
+			return fmt.Errorf("Invalid %s: must be an RFC 3339 time", param.name)
+		}
+		*param.t = value
 	}
 
-	respondWithJSON(w, http.StatusOK, chirps)
-}
\ No newline at end of file
+	if params.Has("contains") {
+		query.Contains = params.Get("contains")
+		if query.Contains == "" {
+			return errors.New("Contains must not be empty")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ArrayOfLilly/chirpy/internal/auth"
+	"github.com/ArrayOfLilly/chirpy/internal/database"
+)
+
+type Like struct {
+	UserID  int       `json:"user_id"`
+	LikedAt time.Time `json:"liked_at"`
+}
+
+// viewerID returns the ID of the user whose JWT the request carries, or 0
+// for a request without a valid one. Public endpoints use it to tell a
+// viewer which chirps they liked, and treat a request whose JWT is
+// missing, expired or invalid as anonymous rather than refusing it.
+func (cfg *apiConfig) viewerID(r *http.Request) int {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return 0
+	}
+	subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		return 0
+	}
+	userID, err := strconv.Atoi(subject)
+	if err != nil {
+		return 0
+	}
+	return userID
+}
+
+// addLikes fills in the like counts of chirps and whether viewerID liked
+// them, with one lookup for all of them.
+func (cfg *apiConfig) addLikes(viewerID int, chirps ...*Chirp) error {
+	if len(chirps) == 0 {
+		return nil
+	}
+	chirpIDs := make([]int, 0, len(chirps))
+	for _, chirp := range chirps {
+		chirpIDs = append(chirpIDs, chirp.ID)
+	}
+	summaries, err := cfg.DB.GetLikeSummaries(chirpIDs, viewerID)
+	if err != nil {
+		return err
+	}
+	for _, chirp := range chirps {
+		summary := summaries[chirp.ID]
+		chirp.LikeCount = summary.Count
+		chirp.LikedByMe = summary.LikedByViewer
+	}
+	return nil
+}
+
+// chirpPointers returns pointers to the elements of chirps, for
+// expandChirps.
+func chirpPointers(chirps []Chirp) []*Chirp {
+	pointers := make([]*Chirp, 0, len(chirps))
+	for i := range chirps {
+		pointers = append(pointers, &chirps[i])
+	}
+	return pointers
+}
+
+func (cfg *apiConfig) handlerChirpsLike(w http.ResponseWriter, r *http.Request) {
+	cfg.handleLikeChange(w, r, cfg.DB.LikeChirp)
+}
+
+func (cfg *apiConfig) handlerChirpsUnlike(w http.ResponseWriter, r *http.Request) {
+	cfg.handleLikeChange(w, r, cfg.DB.UnlikeChirp)
+}
+
+// handleLikeChange applies change, LikeChirp or UnlikeChirp, for the
+// authenticated user and responds with the chirp and its new like count.
+// Liking twice or unliking a chirp that isn't liked changes nothing.
+func (cfg *apiConfig) handleLikeChange(w http.ResponseWriter, r *http.Request, change func(chirpID, userID int) error) {
+	chirpID, err := strconv.Atoi(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		return
+	}
+	subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+		return
+	}
+	userID, err := strconv.Atoi(subject)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't parse user ID")
+		return
+	}
+
+	err = change(chirpID, userID)
+	if errors.Is(err, database.ErrUnauthorized) {
+		respondWithError(w, http.StatusUnauthorized, "User doesn't exist")
+		return
+	}
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Couldn't get chirp")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update like")
+		return
+	}
+
+	dbChirp, err := cfg.DB.GetChirp(chirpID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get chirp")
+		return
+	}
+	chirp := chirpFromDB(dbChirp)
+	err = cfg.expandChirps(userID, &chirp)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chirp details")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, chirp)
+}
+
+// handlerChirpLikes lists who liked a chirp, most recent like first.
+func (cfg *apiConfig) handlerChirpLikes(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := strconv.Atoi(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	dbLikes, err := cfg.DB.GetLikes(chirpID)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Couldn't get chirp")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get likes")
+		return
+	}
+
+	likes := make([]Like, 0, len(dbLikes))
+	for _, dbLike := range dbLikes {
+		likes = append(likes, Like{
+			UserID:  dbLike.UserID,
+			LikedAt: dbLike.CreatedAt,
+		})
+	}
+	respondWithJSON(w, http.StatusOK, likes)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"unicode/utf8"
+
+	"github.com/ArrayOfLilly/chirpy/internal/database"
+)
+
+type Mention struct {
+	UserID int `json:"user_id"`
+	// Start and End are the character offsets of the mention in the body,
+	// from its @ up to but not including End.
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// mentionPattern matches an @ followed by an email address, at the start
+// of the body or after a character that can't be part of an address. A
+// trailing full stop is left out, as it ends the sentence.
+//
+// Only @email mentions are supported. Users have no handle, so @handle
+// mentions are out of scope until one is added: "@alice" on its own is
+// left as plain text rather than guessed from email addresses.
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@.%+-])(@[\w.%+-]+@[\w-]+(?:\.[\w-]+)+)`)
+
+// findMentions returns the mentions in a chirp body, which is matched
+// after censoring. Each mention is looked up by email; those that don't
+// name a user, or name one in the trash, are left out.
+func (cfg *apiConfig) findMentions(body string) ([]database.Mention, error) {
+	mentions := []database.Mention{}
+	userIDs := map[string]int{}
+	for _, match := range mentionPattern.FindAllStringSubmatchIndex(body, -1) {
+		start, end := match[2], match[3]
+		email := body[start+1 : end]
+		userID, ok := userIDs[email]
+		if !ok {
+			user, err := cfg.DB.GetUserByEmail(email)
+			if err != nil && !errors.Is(err, database.ErrNotExist) {
+				return nil, err
+			}
+			userID = user.ID
+			userIDs[email] = userID
+		}
+		if userID == 0 {
+			continue
+		}
+		runeStart := utf8.RuneCountInString(body[:start])
+		mentions = append(mentions, database.Mention{
+			UserID: userID,
+			Start:  runeStart,
+			End:    runeStart + utf8.RuneCountInString(body[start:end]),
+		})
+	}
+	return mentions, nil
+}
+
+func mentionsFromDB(dbMentions []database.Mention) []Mention {
+	if len(dbMentions) == 0 {
+		return nil
+	}
+	mentions := make([]Mention, 0, len(dbMentions))
+	for _, dbMention := range dbMentions {
+		mentions = append(mentions, Mention{
+			UserID: dbMention.UserID,
+			Start:  dbMention.Start,
+			End:    dbMention.End,
+		})
+	}
+	return mentions
+}
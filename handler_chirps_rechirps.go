@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/ArrayOfLilly/chirpy/internal/auth"
+	"github.com/ArrayOfLilly/chirpy/internal/database"
+)
+
+// ChirpRef is a chirp embedded in a rechirp or quote chirp. A chirp that
+// was deleted is a placeholder carrying only its ID and
+// "unavailable": true. Embedded chirps don't embed the chirps they refer
+// to in turn.
+type ChirpRef struct {
+	*Chirp
+	ID          int  `json:"id"`
+	Unavailable bool `json:"unavailable,omitempty"`
+}
+
+// expandChirps embeds the chirps that chirps rechirp or quote and fills in
+// the like counts of all of them for viewerID.
+func (cfg *apiConfig) expandChirps(viewerID int, chirps ...*Chirp) error {
+	embedded, err := cfg.addReferences(chirps...)
+	if err != nil {
+		return err
+	}
+	return cfg.addLikes(viewerID, append(chirps, embedded...)...)
+}
+
+// addReferences embeds the chirps that chirps rechirp or quote, with one
+// lookup for all of them, and returns the embedded chirps that are
+// available.
+func (cfg *apiConfig) addReferences(chirps ...*Chirp) ([]*Chirp, error) {
+	ids := []int{}
+	for _, chirp := range chirps {
+		if chirp.RechirpOf != 0 {
+			ids = append(ids, chirp.RechirpOf)
+		}
+		if chirp.QuotedChirpID != 0 {
+			ids = append(ids, chirp.QuotedChirpID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	dbChirps, err := cfg.DB.GetChirpsByID(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	embedded := []*Chirp{}
+	ref := func(id int) *ChirpRef {
+		dbChirp, ok := dbChirps[id]
+		if !ok {
+			return &ChirpRef{ID: id, Unavailable: true}
+		}
+		chirp := chirpFromDB(dbChirp)
+		embedded = append(embedded, &chirp)
+		return &ChirpRef{Chirp: &chirp, ID: id}
+	}
+	for _, chirp := range chirps {
+		if chirp.RechirpOf != 0 {
+			chirp.RechirpedChirp = ref(chirp.RechirpOf)
+		}
+		if chirp.QuotedChirpID != 0 {
+			chirp.QuotedChirp = ref(chirp.QuotedChirpID)
+		}
+	}
+	return embedded, nil
+}
+
+// handlerChirpsRechirp shares a chirp as the authenticated user and
+// responds with their rechirp. Rechirping a chirp again responds with the
+// earlier rechirp.
+func (cfg *apiConfig) handlerChirpsRechirp(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := strconv.Atoi(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		return
+	}
+	subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+		return
+	}
+	userID, err := strconv.Atoi(subject)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't parse user ID")
+		return
+	}
+
+	dbChirp, err := cfg.DB.RechirpChirp(chirpID, userID)
+	if errors.Is(err, database.ErrUnauthorized) {
+		respondWithError(w, http.StatusUnauthorized, "User doesn't exist")
+		return
+	}
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Couldn't get chirp")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't rechirp chirp")
+		return
+	}
+
+	chirp := chirpFromDB(dbChirp)
+	err = cfg.expandChirps(userID, &chirp)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chirp details")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, chirp)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ArrayOfLilly/chirpy/internal/database"
+)
+
+type ChirpRevision struct {
+	Revision   int       `json:"revision"`
+	Body       string    `json:"body"`
+	CreatedAt  time.Time `json:"created_at"`
+	ReplacedAt time.Time `json:"replaced_at"`
+}
+
+// handlerChirpRevisions lists the bodies a chirp had before its edits,
+// oldest first.
+func (cfg *apiConfig) handlerChirpRevisions(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := strconv.Atoi(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	dbRevisions, err := cfg.DB.GetChirpRevisions(chirpID)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Couldn't get chirp")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chirp revisions")
+		return
+	}
+
+	revisions := make([]ChirpRevision, 0, len(dbRevisions))
+	for _, dbRevision := range dbRevisions {
+		revisions = append(revisions, ChirpRevision{
+			Revision:   dbRevision.Revision,
+			Body:       dbRevision.Body,
+			CreatedAt:  dbRevision.CreatedAt,
+			ReplacedAt: dbRevision.ReplacedAt,
+		})
+	}
+	respondWithJSON(w, http.StatusOK, revisions)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/ArrayOfLilly/chirpy/internal/database"
+)
+
+// handlerChirpsSearch runs the full-text query q over chirp bodies and
+// returns a page of the matches, best first, with the total number of
+// matches. Pages are selected by limit and offset; results are ranked
+// partly by age, so they can shift between requests.
+func (cfg *apiConfig) handlerChirpsSearch(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Chirps []Chirp `json:"chirps"`
+		Total  int     `json:"total"`
+	}
+
+	params := r.URL.Query()
+	query := database.SearchQuery{
+		Query: params.Get("q"),
+		Limit: defaultChirpPageSize,
+	}
+	if query.Query == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing search query q")
+		return
+	}
+	if params.Has("limit") {
+		limit, err := strconv.Atoi(params.Get("limit"))
+		if err != nil || limit < 1 || limit > maxChirpPageSize {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Limit must be between 1 and %d", maxChirpPageSize))
+			return
+		}
+		query.Limit = limit
+	}
+	if params.Has("offset") {
+		offset, err := strconv.Atoi(params.Get("offset"))
+		if err != nil || offset < 0 {
+			respondWithError(w, http.StatusBadRequest, "Invalid offset")
+			return
+		}
+		query.Offset = offset
+	}
+
+	viewerID := cfg.viewerID(r)
+
+	result, err := cfg.DB.SearchChirps(query)
+	if errors.Is(err, database.ErrInvalidSearch) {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't search chirps")
+		return
+	}
+
+	resp := response{
+		Chirps: make([]Chirp, 0, len(result.Chirps)),
+		Total:  result.Total,
+	}
+	for _, dbChirp := range result.Chirps {
+		resp.Chirps = append(resp.Chirps, chirpFromDB(dbChirp))
+	}
+	err = cfg.expandChirps(viewerID, chirpPointers(resp.Chirps)...)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chirp details")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
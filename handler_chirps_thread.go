@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/ArrayOfLilly/chirpy/internal/database"
+)
+
+const (
+	defaultThreadDepth = 10
+	maxThreadDepth     = 50
+)
+
+// ThreadNode is a chirp in a thread with the replies below it. A deleted
+// chirp that still has replies is a placeholder carrying only its ID and
+// "deleted": true.
+type ThreadNode struct {
+	*Chirp
+	ID         int          `json:"id"`
+	Deleted    bool         `json:"deleted,omitempty"`
+	ReplyCount int          `json:"reply_count"`
+	Replies    []ThreadNode `json:"replies"`
+}
+
+func threadNodeFromDB(dbNode database.ThreadNode) ThreadNode {
+	node := ThreadNode{
+		ID:         dbNode.ID,
+		Deleted:    dbNode.Chirp == nil,
+		ReplyCount: dbNode.ReplyCount,
+		Replies:    make([]ThreadNode, 0, len(dbNode.Replies)),
+	}
+	if dbNode.Chirp != nil {
+		chirp := chirpFromDB(*dbNode.Chirp)
+		node.Chirp = &chirp
+	}
+	for _, reply := range dbNode.Replies {
+		node.Replies = append(node.Replies, threadNodeFromDB(reply))
+	}
+	return node
+}
+
+// chirps returns the chirps in the thread below and including node,
+// skipping placeholders.
+func (node *ThreadNode) chirps() []*Chirp {
+	chirps := []*Chirp{}
+	if node.Chirp != nil {
+		chirps = append(chirps, node.Chirp)
+	}
+	for i := range node.Replies {
+		chirps = append(chirps, node.Replies[i].chirps()...)
+	}
+	return chirps
+}
+
+// handlerChirpThread returns a chirp and the replies below it as a tree,
+// oldest reply first at each level, down to depth levels of replies.
+// reply_count tells how many replies a chirp has where the depth limit
+// cut them off.
+func (cfg *apiConfig) handlerChirpThread(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := strconv.Atoi(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	depth := defaultThreadDepth
+	if depthStr := r.URL.Query().Get("depth"); depthStr != "" {
+		depth, err = strconv.Atoi(depthStr)
+		if err != nil || depth < 0 || depth > maxThreadDepth {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Depth must be between 0 and %d", maxThreadDepth))
+			return
+		}
+	}
+
+	viewerID := cfg.viewerID(r)
+
+	thread, err := cfg.DB.GetThread(chirpID, depth)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Couldn't get chirp")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get thread")
+		return
+	}
+
+	node := threadNodeFromDB(thread)
+	err = cfg.expandChirps(viewerID, node.chirps()...)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chirp details")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, node)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ArrayOfLilly/chirpy/internal/auth"
+	"github.com/ArrayOfLilly/chirpy/internal/database"
+)
+
+// handlerChirpsUpdate lets the author of a chirp replace its body while
+// the edit window after posting is open. The new body is validated and its
+// mentions found like a new chirp's, and the old one is kept as a
+// revision.
+func (cfg *apiConfig) handlerChirpsUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Body string `json:"body"`
+	}
+
+	chirpIDString := r.PathValue("chirpID")
+	chirpID, err := strconv.Atoi(chirpIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		return
+	}
+	subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+		return
+	}
+	userID, err := strconv.Atoi(subject)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse user ID")
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	cleaned, err := validateChirp(params.Body)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	dbChirp, err := cfg.DB.GetChirp(chirpID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get chirp")
+		return
+	}
+	if dbChirp.AuthorID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't edit this chirp")
+		return
+	}
+	if cfg.editWindow > 0 && time.Since(dbChirp.CreatedAt) > cfg.editWindow {
+		respondWithError(w, http.StatusForbidden, "The edit window for this chirp has closed")
+		return
+	}
+
+	mentions, err := cfg.findMentions(cleaned)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't look up mentions")
+		return
+	}
+
+	dbChirp, err = cfg.DB.EditChirp(chirpID, cleaned, mentions)
+	if errors.Is(err, database.ErrUnauthorized) {
+		respondWithError(w, http.StatusUnauthorized, "User doesn't exist")
+		return
+	}
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Couldn't get chirp")
+		return
+	}
+	if errors.Is(err, database.ErrRechirpEdit) {
+		respondWithError(w, http.StatusBadRequest, "Rechirps can't be edited")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't edit chirp")
+		return
+	}
+
+	chirp := chirpFromDB(dbChirp)
+	err = cfg.expandChirps(userID, &chirp)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chirp details")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, chirp)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/ArrayOfLilly/chirpy/internal/auth"
+)
+
+// handlerTimeline returns a page of the authenticated user's home
+// timeline: their own chirps and those of the users they follow, newest
+// first, with the next_cursor to continue from.
+func (cfg *apiConfig) handlerTimeline(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Chirps     []Chirp `json:"chirps"`
+		NextCursor string  `json:"next_cursor,omitempty"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		return
+	}
+	subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+		return
+	}
+	userID, err := strconv.Atoi(subject)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't parse user ID")
+		return
+	}
+
+	limit, afterID, err := parsePageParams(r.URL.Query(), true)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	page, err := cfg.DB.GetTimeline(userID, afterID, limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get timeline")
+		return
+	}
+
+	resp := response{
+		Chirps: make([]Chirp, 0, len(page.Chirps)),
+	}
+	for _, dbChirp := range page.Chirps {
+		resp.Chirps = append(resp.Chirps, chirpFromDB(dbChirp))
+	}
+	err = cfg.expandChirps(userID, chirpPointers(resp.Chirps)...)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chirp details")
+		return
+	}
+	if page.More {
+		resp.NextCursor = encodeChirpCursor(chirpCursor{
+			AfterID:    resp.Chirps[len(resp.Chirps)-1].ID,
+			Descending: true,
+		})
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
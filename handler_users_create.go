@@ -5,6 +5,7 @@ import (
 	"errors"
 	"net/http"
 	"net/mail"
+	"time"
 
 	"github.com/ArrayOfLilly/chirpy/internal/auth"
 	"github.com/ArrayOfLilly/chirpy/internal/database"
@@ -15,6 +16,20 @@ type User struct {
 	Email string `json:"email"`
 	Password string `json:"password"`
 	IsChirpyRed bool `json:"is_chirpy_red"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// userFromDB converts a stored user to its API representation, which
+// never includes the password.
+func userFromDB(dbUser database.User) User {
+	return User{
+		ID:          dbUser.ID,
+		Email:       dbUser.Email,
+		IsChirpyRed: dbUser.IsChirpyRed,
+		CreatedAt:   dbUser.CreatedAt,
+		UpdatedAt:   dbUser.UpdatedAt,
+	}
 }
 
 
@@ -55,9 +70,5 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	respondWithJSON(w, http.StatusCreated, User{
-		ID:   user.ID,
-		Email: user.Email,
-		IsChirpyRed: user.IsChirpyRed,
-	})
+	respondWithJSON(w, http.StatusCreated, userFromDB(user))
 }
\ No newline at end of file
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/ArrayOfLilly/chirpy/internal/auth"
+	"github.com/ArrayOfLilly/chirpy/internal/database"
+)
+
+func (cfg *apiConfig) handlerUsersFollow(w http.ResponseWriter, r *http.Request) {
+	cfg.handleFollowChange(w, r, cfg.DB.FollowUser)
+}
+
+func (cfg *apiConfig) handlerUsersUnfollow(w http.ResponseWriter, r *http.Request) {
+	cfg.handleFollowChange(w, r, cfg.DB.UnfollowUser)
+}
+
+// handleFollowChange applies change, FollowUser or UnfollowUser, from the
+// authenticated user to the user in the path and responds with that
+// user's profile. Following twice or unfollowing a user who isn't
+// followed changes nothing.
+func (cfg *apiConfig) handleFollowChange(w http.ResponseWriter, r *http.Request, change func(followerID, followeeID int) error) {
+	followeeID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		return
+	}
+	subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+		return
+	}
+	userID, err := strconv.Atoi(subject)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't parse user ID")
+		return
+	}
+
+	err = change(userID, followeeID)
+	if errors.Is(err, database.ErrSelfFollow) {
+		respondWithError(w, http.StatusBadRequest, "You can't follow yourself")
+		return
+	}
+	if errors.Is(err, database.ErrUnauthorized) {
+		respondWithError(w, http.StatusUnauthorized, "User doesn't exist")
+		return
+	}
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update follow")
+		return
+	}
+
+	profile, err := cfg.getProfile(followeeID, userID)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, profile)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/ArrayOfLilly/chirpy/internal/auth"
+)
+
+// handlerUserMentions returns a page of the chirps mentioning the
+// authenticated user, newest first, with the next_cursor to continue from
+// and how many of their mentions are unread.
+func (cfg *apiConfig) handlerUserMentions(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Chirps      []Chirp `json:"chirps"`
+		NextCursor  string  `json:"next_cursor,omitempty"`
+		UnreadCount int     `json:"unread_count"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		return
+	}
+	subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+		return
+	}
+	userID, err := strconv.Atoi(subject)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't parse user ID")
+		return
+	}
+
+	limit, afterID, err := parsePageParams(r.URL.Query(), true)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	page, err := cfg.DB.GetMentions(userID, afterID, limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get mentions")
+		return
+	}
+
+	resp := response{
+		Chirps:      make([]Chirp, 0, len(page.Chirps)),
+		UnreadCount: page.Unread,
+	}
+	for _, dbChirp := range page.Chirps {
+		resp.Chirps = append(resp.Chirps, chirpFromDB(dbChirp))
+	}
+	err = cfg.expandChirps(userID, chirpPointers(resp.Chirps)...)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chirp details")
+		return
+	}
+	if page.More {
+		resp.NextCursor = encodeChirpCursor(chirpCursor{
+			AfterID:    resp.Chirps[len(resp.Chirps)-1].ID,
+			Descending: true,
+		})
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// handlerUserMentionsRead marks all of the authenticated user's mentions
+// read.
+func (cfg *apiConfig) handlerUserMentionsRead(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		return
+	}
+	subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+		return
+	}
+	userID, err := strconv.Atoi(subject)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't parse user ID")
+		return
+	}
+
+	err = cfg.DB.MarkMentionsRead(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't mark mentions read")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
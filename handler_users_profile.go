@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ArrayOfLilly/chirpy/internal/database"
+)
+
+// Profile is the public view of a user. Unlike User it leaves out the
+// email.
+type Profile struct {
+	ID             int       `json:"id"`
+	IsChirpyRed    bool      `json:"is_chirpy_red"`
+	CreatedAt      time.Time `json:"created_at"`
+	FollowerCount  int       `json:"follower_count"`
+	FollowingCount int       `json:"following_count"`
+	FollowedByMe   bool      `json:"followed_by_me"`
+}
+
+// getProfile returns the profile of the user as viewerID, 0 for nobody,
+// sees it.
+func (cfg *apiConfig) getProfile(userID, viewerID int) (Profile, error) {
+	dbUser, err := cfg.DB.GetUser(userID)
+	if err != nil {
+		return Profile{}, err
+	}
+	summary, err := cfg.DB.GetFollowSummary(userID, viewerID)
+	if err != nil {
+		return Profile{}, err
+	}
+	return Profile{
+		ID:             dbUser.ID,
+		IsChirpyRed:    dbUser.IsChirpyRed,
+		CreatedAt:      dbUser.CreatedAt,
+		FollowerCount:  summary.Followers,
+		FollowingCount: summary.Following,
+		FollowedByMe:   summary.FollowedByViewer,
+	}, nil
+}
+
+func (cfg *apiConfig) handlerUserProfile(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	viewerID := cfg.viewerID(r)
+
+	profile, err := cfg.getProfile(userID, viewerID)
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, profile)
+}
@@ -2,6 +2,7 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -90,22 +91,19 @@ func MakeRefreshToken() (string, error) {
 
 func GetApiKey(headers http.Header) (string, error) {
 	authHeader := headers.Get("Authorization")
-	fmt.Printf("%v\n", authHeader)
 	if authHeader == "" {
 		return "", ErrNoAuthHeaderIncluded
 	}
 	splitAuth := strings.Split(authHeader, " ")
-	fmt.Printf("%v\n", splitAuth)
 	if len(splitAuth) < 2 || splitAuth[0] != "ApiKey" {
 		return "", errors.New("malformed authorization header")
 	}
 
-	fmt.Printf("%v\n", splitAuth[1])
 	return splitAuth[1], nil
 }
 
+// CheckApiKey compares the keys in constant time, so the comparison
+// doesn't reveal how much of a guessed key is right.
 func CheckApiKey(savedApiKey string, apiKey string) bool {
-	fmt.Printf("savedApiKey %v\n", savedApiKey)
-	fmt.Printf("apiKey %v\n", apiKey)
-	return (savedApiKey == apiKey)
+	return subtle.ConstantTimeCompare([]byte(savedApiKey), []byte(apiKey)) == 1
 }
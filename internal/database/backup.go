@@ -0,0 +1,197 @@
+package database
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrChecksumMismatch is returned when a backup's contents don't match the
+// checksum recorded in it.
+var ErrChecksumMismatch = errors.New("backup checksum mismatch")
+
+// ErrInvalidBackupName is returned for names that don't refer to a backup
+// file inside the backup directory.
+var ErrInvalidBackupName = errors.New("invalid backup name")
+
+const (
+	backupPrefix     = "chirpy-"
+	backupSuffix     = ".json"
+	backupTimeFormat = "20060102T150405.000000000Z"
+)
+
+// Backups manages backup files in a directory. With Cipher set, backups
+// are encrypted like the database itself.
+type Backups struct {
+	Dir    string
+	Cipher *Cipher
+}
+
+// BackupInfo describes a backup file.
+type BackupInfo struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	Checksum  string    `json:"checksum"`
+	Size      int64     `json:"size"`
+}
+
+// backupFile is the on-disk format of a backup: the snapshot plus the time
+// it was taken and the SHA-256 of the snapshot's encoding.
+type backupFile struct {
+	CreatedAt time.Time       `json:"created_at"`
+	Checksum  string          `json:"checksum"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Write takes a consistent snapshot of store and writes it to a new
+// timestamped file.
+func (b Backups) Write(store Store) (BackupInfo, error) {
+	dbStructure, err := store.Snapshot()
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	data, err := json.Marshal(dbStructure)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+
+	createdAt := time.Now().UTC()
+	sum := sha256.Sum256(data)
+	dat, err := json.Marshal(backupFile{
+		CreatedAt: createdAt,
+		Checksum:  hex.EncodeToString(sum[:]),
+		Data:      data,
+	})
+	if err != nil {
+		return BackupInfo{}, err
+	}
+
+	dat = encodeFile(b.Cipher, dat)
+
+	err = os.MkdirAll(b.Dir, 0700)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	name := backupPrefix + createdAt.Format(backupTimeFormat) + backupSuffix
+	err = writeFileAtomic(filepath.Join(b.Dir, name), dat)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+
+	return BackupInfo{
+		Name:      name,
+		CreatedAt: createdAt,
+		Checksum:  hex.EncodeToString(sum[:]),
+		Size:      int64(len(dat)),
+	}, nil
+}
+
+// List returns the backups, newest first.
+func (b Backups) List() ([]BackupInfo, error) {
+	entries, err := os.ReadDir(b.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []BackupInfo{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	backups := []BackupInfo{}
+	for _, entry := range entries {
+		if entry.IsDir() || !isBackupName(entry.Name()) {
+			continue
+		}
+		_, info, err := b.Read(entry.Name())
+		if err != nil {
+			// Still list damaged backups so they can be inspected.
+			info = BackupInfo{Name: entry.Name()}
+			fileInfo, statErr := entry.Info()
+			if statErr == nil {
+				info.Size = fileInfo.Size()
+			}
+		}
+		backups = append(backups, info)
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Name > backups[j].Name
+	})
+	return backups, nil
+}
+
+// Read reads the named backup, verifies its checksum, upgrades its
+// contents to the current schema version and validates them.
+func (b Backups) Read(name string) (DBStructure, BackupInfo, error) {
+	if !isBackupName(name) {
+		return DBStructure{}, BackupInfo{}, ErrInvalidBackupName
+	}
+	dat, err := os.ReadFile(filepath.Join(b.Dir, name))
+	if err != nil {
+		return DBStructure{}, BackupInfo{}, err
+	}
+	size := int64(len(dat))
+	dat, err = decodeFile(b.Cipher, dat)
+	if err != nil {
+		return DBStructure{}, BackupInfo{}, fmt.Errorf("%s: %w", name, err)
+	}
+
+	file := backupFile{}
+	err = json.Unmarshal(dat, &file)
+	if err != nil {
+		return DBStructure{}, BackupInfo{}, fmt.Errorf("%w: %s: %v", ErrCorrupt, name, err)
+	}
+	sum := sha256.Sum256(file.Data)
+	if hex.EncodeToString(sum[:]) != file.Checksum {
+		return DBStructure{}, BackupInfo{}, fmt.Errorf("%w: %s", ErrChecksumMismatch, name)
+	}
+
+	dbStructure := DBStructure{}
+	err = json.Unmarshal(file.Data, &dbStructure)
+	if err != nil {
+		return DBStructure{}, BackupInfo{}, fmt.Errorf("%w: %s: %v", ErrCorrupt, name, err)
+	}
+	_, err = dbStructure.upgrade()
+	if err != nil {
+		return DBStructure{}, BackupInfo{}, fmt.Errorf("%s: %w", name, err)
+	}
+	err = dbStructure.Validate()
+	if err != nil {
+		return DBStructure{}, BackupInfo{}, err
+	}
+
+	return dbStructure, BackupInfo{
+		Name:      name,
+		CreatedAt: file.CreatedAt,
+		Checksum:  file.Checksum,
+		Size:      size,
+	}, nil
+}
+
+// Restore validates the named backup and swaps it in for the contents of
+// store.
+func (b Backups) Restore(store Store, name string) (BackupInfo, error) {
+	dbStructure, info, err := b.Read(name)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	err = store.Restore(dbStructure)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	return info, nil
+}
+
+// isBackupName reports whether name is a plain backup file name, so names
+// taken from requests can't reach outside the backup directory.
+func isBackupName(name string) bool {
+	return filepath.Base(name) == name &&
+		strings.HasPrefix(name, backupPrefix) &&
+		strings.HasSuffix(name, backupSuffix)
+}
@@ -1,26 +1,93 @@
 package database
 
+import (
+	"errors"
+	"time"
+)
+
+// ErrInvalidReply is returned when a new chirp replies to a chirp that
+// doesn't exist, is in the trash or is a rechirp.
+var ErrInvalidReply = errors.New("chirp replied to does not exist")
+
 type Chirp struct {
-	ID   int    `json:"id"`
-	Body string `json:"body"`
-	AuthorID int `json:"author_id"`
+	ID       int    `json:"id"`
+	Body     string `json:"body"`
+	AuthorID int    `json:"author_id"`
+	// InReplyTo is the ID of the chirp this one replies to, 0 for none.
+	// It keeps pointing at the parent after the parent is deleted.
+	InReplyTo int `json:"in_reply_to,omitempty"`
+	// RechirpOf is set on a rechirp, which has no body of its own, to the
+	// ID of the chirp it shares. QuotedChirpID is set on a chirp that
+	// quotes another. Both keep pointing at the chirp after it is deleted.
+	RechirpOf     int `json:"rechirp_of,omitempty"`
+	QuotedChirpID int `json:"quoted_chirp_id,omitempty"`
+	// Mentions are the users the body mentions, in the order they appear.
+	Mentions []Mention `json:"mentions,omitempty"`
+	// CreatedAt and UpdatedAt are in UTC.
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// EditedAt is set once the chirp's body has been edited, to the time
+	// of the latest edit.
+	EditedAt *time.Time `json:"edited_at,omitempty"`
+	// DeletedAt is set while the chirp is in the trash. Trashed chirps are
+	// left out of every query except the trash ones.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
-func (db *DB) CreateChirp(body string, authorID int) (Chirp, error) {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return Chirp{}, err
-	}
-
-	id := len(dbStructure.Chirps) + 1
-	chirp := Chirp{
-		ID:   id,
-		Body: body,
-		AuthorID: authorID,
-	}
-	dbStructure.Chirps[id] = chirp
+// NewChirp is a chirp to be posted by CreateChirp.
+type NewChirp struct {
+	Body     string
+	AuthorID int
+	// InReplyTo is the ID of the chirp the new one replies to, 0 for none.
+	InReplyTo int
+	// QuotedChirpID is the ID of the chirp the new one quotes, 0 for none.
+	// Quoting a rechirp quotes the chirp it shares.
+	QuotedChirpID int
+	// Mentions are the users the body mentions; each of them other than
+	// the author is notified.
+	Mentions []Mention
+}
 
-	err = db.writeDB(dbStructure)
+// CreateChirp posts a chirp. It returns ErrUnauthorized if the author
+// doesn't exist or is in the trash, ErrInvalidReply if the chirp it
+// replies to can't be replied to, and ErrInvalidQuote if the chirp it
+// quotes doesn't exist or is in the trash.
+func (db *DB) CreateChirp(newChirp NewChirp) (Chirp, error) {
+	chirp := Chirp{}
+	err := db.update(func(t *tx) error {
+		err := t.activeUser(newChirp.AuthorID)
+		if err != nil {
+			return err
+		}
+		if newChirp.InReplyTo != 0 {
+			parent, ok := t.data.Chirps[newChirp.InReplyTo]
+			if !ok || parent.DeletedAt != nil || parent.RechirpOf != 0 {
+				return ErrInvalidReply
+			}
+		}
+		if newChirp.QuotedChirpID != 0 {
+			quoted, ok := visibleOriginal(t.data.Chirps, newChirp.QuotedChirpID)
+			if !ok {
+				return ErrInvalidQuote
+			}
+			newChirp.QuotedChirpID = quoted.ID
+		}
+		now := time.Now().UTC()
+		chirp = Chirp{
+			ID:            t.data.nextChirpID(),
+			Body:          newChirp.Body,
+			AuthorID:      newChirp.AuthorID,
+			InReplyTo:     newChirp.InReplyTo,
+			QuotedChirpID: newChirp.QuotedChirpID,
+			Mentions:      newChirp.Mentions,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}
+		t.putChirp(chirp)
+		t.pushChirp(chirp, db.fanoutThreshold)
+		t.notifyMentions(chirp, Chirp{})
+		return nil
+	})
 	if err != nil {
 		return Chirp{}, err
 	}
@@ -29,45 +96,94 @@ func (db *DB) CreateChirp(body string, authorID int) (Chirp, error) {
 }
 
 func (db *DB) GetChirps() ([]Chirp, error) {
-	dbStructure, err := db.loadDB()
+	chirps := []Chirp{}
+	err := db.View(func(dbStructure DBStructure) error {
+		chirps = make([]Chirp, 0, len(dbStructure.Chirps))
+		for _, chirp := range dbStructure.Chirps {
+			if chirp.DeletedAt != nil {
+				continue
+			}
+			chirps = append(chirps, chirp)
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	chirps := make([]Chirp, 0, len(dbStructure.Chirps))
-	for _, chirp := range dbStructure.Chirps {
-		chirps = append(chirps, chirp)
-	}
-
 	return chirps, nil
 }
 
 func (db *DB) GetChirp(id int) (Chirp, error) {
-	dbStructure, err := db.loadDB()
+	chirp := Chirp{}
+	err := db.View(func(dbStructure DBStructure) error {
+		var ok bool
+		chirp, ok = dbStructure.Chirps[id]
+		if !ok || chirp.DeletedAt != nil {
+			return ErrNotExist
+		}
+		return nil
+	})
 	if err != nil {
 		return Chirp{}, err
 	}
 
-	chirp, ok := dbStructure.Chirps[id]
-	if !ok {
-		return Chirp{}, ErrNotExist
-	}
-
 	return chirp, nil
 }
 
-func (db *DB) DeleteChirp(id int) error {
-	dbStructure, err := db.loadDB()
+// GetChirpsByID returns the chirps with the IDs, keyed by ID. Chirps that
+// don't exist or are in the trash are left out.
+func (db *DB) GetChirpsByID(ids []int) (map[int]Chirp, error) {
+	chirps := map[int]Chirp{}
+	err := db.View(func(dbStructure DBStructure) error {
+		for _, id := range ids {
+			chirp, ok := dbStructure.Chirps[id]
+			if ok && chirp.DeletedAt == nil {
+				chirps[id] = chirp
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return chirps, nil
+}
 
-	delete(dbStructure.Chirps, id)
-	err = db.writeDB(dbStructure)
+// GetChirpsByAuthor returns the author's chirps in ascending ID order.
+func (db *DB) GetChirpsByAuthor(authorID int) ([]Chirp, error) {
+	chirps := []Chirp{}
+	err := db.View(func(dbStructure DBStructure) error {
+		ids := db.idx.chirpsByAuthor[authorID]
+		chirps = make([]Chirp, 0, len(ids))
+		for _, id := range ids {
+			chirps = append(chirps, dbStructure.Chirps[id])
+		}
+		return nil
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return chirps, nil
 }
 
+// DeleteChirp moves the chirp to the trash, from which RestoreChirp can
+// bring it back until PurgeTrash removes it for good. It returns
+// ErrUnauthorized if the chirp's author is in the trash.
+func (db *DB) DeleteChirp(id int) error {
+	return db.update(func(t *tx) error {
+		chirp, ok := t.data.Chirps[id]
+		if !ok || chirp.DeletedAt != nil {
+			return nil
+		}
+		err := t.activeUser(chirp.AuthorID)
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		chirp.DeletedAt = &now
+		t.putChirp(chirp)
+		return nil
+	})
+}
@@ -0,0 +1,147 @@
+package database
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestCipher(t *testing.T) *Cipher {
+	t.Helper()
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	c, err := NewCipher(key)
+	if err != nil {
+		t.Fatalf("creating cipher: %v", err)
+	}
+	return c
+}
+
+// TestEncryptedFilesHoldNoPlaintext checks that with a key configured,
+// neither the WAL, the snapshot, its generations nor a backup contain the
+// data written to them.
+func TestEncryptedFilesHoldNoPlaintext(t *testing.T) {
+	const (
+		email = "secret-address@example.com"
+		body  = "a secret chirp body"
+	)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "database.json")
+	opts := DefaultOptions()
+	opts.Durability = DurabilitySync
+	opts.Cipher = newTestCipher(t)
+	db, err := NewDBWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	user, err := db.CreateUser(email, "hash")
+	if err != nil {
+		t.Fatalf("creating user: %v", err)
+	}
+	_, err = db.CreateChirp(NewChirp{Body: body, AuthorID: user.ID})
+	if err != nil {
+		t.Fatalf("creating chirp: %v", err)
+	}
+
+	checkFile := func(path string) {
+		t.Helper()
+		dat, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("reading %s: %v", path, err)
+		}
+		for _, secret := range []string{email, body} {
+			if bytes.Contains(dat, []byte(secret)) {
+				t.Errorf("%s contains %q in plaintext", filepath.Base(path), secret)
+			}
+		}
+	}
+
+	// The changes are in the WAL only so far.
+	checkFile(walPath(path))
+	_, err = db.UpdateUser(user.ID, email, "new hash")
+	if err != nil {
+		t.Fatalf("updating user: %v", err)
+	}
+	err = db.Compact()
+	if err != nil {
+		t.Fatalf("compacting: %v", err)
+	}
+	backups := Backups{Dir: filepath.Join(dir, "backups"), Cipher: opts.Cipher}
+	info, err := backups.Write(db)
+	if err != nil {
+		t.Fatalf("writing backup: %v", err)
+	}
+	err = db.Close()
+	if err != nil {
+		t.Fatalf("closing database: %v", err)
+	}
+
+	// The closing snapshot rotated the compacted one into the generations.
+	checkFile(path)
+	checkFile(generationPath(path, 1))
+	checkFile(filepath.Join(backups.Dir, info.Name))
+}
+
+// TestEncryptedFilesNeedTheirKey checks that an encrypted database and its
+// backups can't be read with another key or without one.
+func TestEncryptedFilesNeedTheirKey(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "database.json")
+	opts := DefaultOptions()
+	opts.Cipher = newTestCipher(t)
+	db, err := NewDBWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	_, err = db.CreateUser("author@example.com", "hash")
+	if err != nil {
+		t.Fatalf("creating user: %v", err)
+	}
+	backups := Backups{Dir: filepath.Join(dir, "backups"), Cipher: opts.Cipher}
+	info, err := backups.Write(db)
+	if err != nil {
+		t.Fatalf("writing backup: %v", err)
+	}
+	err = db.Close()
+	if err != nil {
+		t.Fatalf("closing database: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		cipher *Cipher
+		want   error
+	}{
+		{"wrong key", newTestCipher(t), ErrWrongKey},
+		{"no key", nil, ErrNoKey},
+	}
+	for _, tt := range tests {
+		opts := opts
+		opts.Cipher = tt.cipher
+		_, err := NewDBWithOptions(path, opts)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("opening database with %s: got %v, want %v", tt.name, err, tt.want)
+		}
+		_, _, err = Backups{Dir: backups.Dir, Cipher: tt.cipher}.Read(info.Name)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("reading backup with %s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	// The right key still opens it; the failed attempts changed nothing.
+	db, err = NewDBWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("reopening with the right key: %v", err)
+	}
+	defer db.Close()
+	_, err = db.GetUserByEmail("author@example.com")
+	if err != nil {
+		t.Errorf("getting user: %v", err)
+	}
+}
@@ -0,0 +1,381 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestConcurrentWritesSurviveReopen runs users creating accounts, posting
+// and liking in parallel, then reopens the file and checks that every
+// write and the ID sequences were persisted.
+func TestConcurrentWritesSurviveReopen(t *testing.T) {
+	for _, mode := range []string{"write-behind", "sync"} {
+		durability, err := ParseDurability(mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Run(mode, func(t *testing.T) {
+			testConcurrentWritesSurviveReopen(t, durability)
+		})
+	}
+}
+
+func testConcurrentWritesSurviveReopen(t *testing.T, durability Durability) {
+	const (
+		writers          = 8
+		chirpsPerWriter  = 25
+		expectedChirps   = writers * chirpsPerWriter
+		expectedUsers    = writers
+		expectedLikesPer = writers
+	)
+
+	path := filepath.Join(t.TempDir(), "database.json")
+	opts := DefaultOptions()
+	opts.Durability = durability
+	opts.FlushInterval = 10 * time.Millisecond
+	db, err := NewDBWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+
+	// Every writer creates a user and posts its chirps.
+	users := make([]User, writers)
+	chirpIDs := make([][]int, writers)
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			user, err := db.CreateUser(fmt.Sprintf("user%d@example.com", i), "hash")
+			if err != nil {
+				errs <- fmt.Errorf("creating user %d: %w", i, err)
+				return
+			}
+			users[i] = user
+			for j := 0; j < chirpsPerWriter; j++ {
+				chirp, err := db.CreateChirp(NewChirp{
+					Body:     fmt.Sprintf("chirp %d from user %d", j, i),
+					AuthorID: user.ID,
+				})
+				if err != nil {
+					errs <- fmt.Errorf("creating chirp %d of user %d: %w", j, i, err)
+					return
+				}
+				chirpIDs[i] = append(chirpIDs[i], chirp.ID)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	// Then every user likes every chirp, all at once.
+	all := []int{}
+	for _, ids := range chirpIDs {
+		all = append(all, ids...)
+	}
+	errs = make(chan error, writers)
+	for _, user := range users {
+		wg.Add(1)
+		go func(user User) {
+			defer wg.Done()
+			for _, chirpID := range all {
+				err := db.LikeChirp(chirpID, user.ID)
+				if err != nil {
+					errs <- fmt.Errorf("user %d liking chirp %d: %w", user.ID, chirpID, err)
+					return
+				}
+			}
+		}(user)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	err = db.Close()
+	if err != nil {
+		t.Fatalf("closing database: %v", err)
+	}
+
+	db, err = NewDBWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("reopening database: %v", err)
+	}
+	defer db.Close()
+
+	dbStructure, err := db.Snapshot()
+	if err != nil {
+		t.Fatalf("reading snapshot: %v", err)
+	}
+	if len(dbStructure.Users) != expectedUsers {
+		t.Errorf("got %d users, want %d", len(dbStructure.Users), expectedUsers)
+	}
+	if len(dbStructure.Chirps) != expectedChirps {
+		t.Errorf("got %d chirps, want %d", len(dbStructure.Chirps), expectedChirps)
+	}
+	for i, user := range users {
+		got, err := db.GetUser(user.ID)
+		if err != nil || got.Email != user.Email {
+			t.Errorf("user %d: got %+v, %v", user.ID, got, err)
+		}
+		for _, chirpID := range chirpIDs[i] {
+			chirp, err := db.GetChirp(chirpID)
+			if err != nil || chirp.AuthorID != user.ID {
+				t.Errorf("chirp %d: got %+v, %v", chirpID, chirp, err)
+			}
+		}
+	}
+	for _, chirpID := range all {
+		likes, err := db.GetLikes(chirpID)
+		if err != nil {
+			t.Fatalf("getting likes of chirp %d: %v", chirpID, err)
+		}
+		if len(likes) != expectedLikesPer {
+			t.Errorf("chirp %d has %d likes, want %d", chirpID, len(likes), expectedLikesPer)
+		}
+	}
+	if dbStructure.Sequences.Users != expectedUsers {
+		t.Errorf("user sequence is %d, want %d", dbStructure.Sequences.Users, expectedUsers)
+	}
+	if dbStructure.Sequences.Chirps != expectedChirps {
+		t.Errorf("chirp sequence is %d, want %d", dbStructure.Sequences.Chirps, expectedChirps)
+	}
+
+	// New IDs continue from the persisted sequences.
+	user, err := db.CreateUser("late@example.com", "hash")
+	if err != nil {
+		t.Fatalf("creating user after reopen: %v", err)
+	}
+	if user.ID != expectedUsers+1 {
+		t.Errorf("new user got ID %d, want %d", user.ID, expectedUsers+1)
+	}
+}
+
+// TestFailedSyncWriteIsRolledBack checks that in DurabilitySync mode a
+// mutation whose WAL append fails leaves nothing behind: not the record,
+// not its ID and not a feed event, so retrying it succeeds.
+func TestFailedSyncWriteIsRolledBack(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.json")
+	opts := DefaultOptions()
+	opts.Durability = DurabilitySync
+	db, err := NewDBWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	defer db.Close()
+	sub, err := db.Feed().Subscribe(db.Feed().Seq(), 10)
+	if err != nil {
+		t.Fatalf("subscribing: %v", err)
+	}
+	defer sub.Close()
+
+	// Appends fail while the WAL is closed underneath the database.
+	err = db.wal.Close()
+	if err != nil {
+		t.Fatalf("closing WAL: %v", err)
+	}
+	_, err = db.CreateUser("retry@example.com", "hash")
+	if err == nil {
+		t.Fatal("creating user with a closed WAL succeeded")
+	}
+	_, err = db.GetUserByEmail("retry@example.com")
+	if !errors.Is(err, ErrNotExist) {
+		t.Errorf("getting user after the failed write: got %v, want ErrNotExist", err)
+	}
+	if seq := db.Feed().Seq(); seq != 0 {
+		t.Errorf("feed is at %d after the failed write, want 0", seq)
+	}
+
+	db.wal, err = os.OpenFile(walPath(path), os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatalf("reopening WAL: %v", err)
+	}
+	user, err := db.CreateUser("retry@example.com", "hash")
+	if err != nil {
+		t.Fatalf("retrying: %v", err)
+	}
+	if user.ID != 1 {
+		t.Errorf("retried user got ID %d, want 1", user.ID)
+	}
+	event := <-sub.C
+	if event.User == nil || event.User.ID != user.ID {
+		t.Errorf("got event %+v, want the retried user", event)
+	}
+}
+
+// TestUpdate checks that Update swaps in the changes fn makes, persists
+// them, and leaves the database untouched when fn fails, the result is
+// invalid, or in DurabilitySync mode the snapshot can't be written.
+func TestUpdate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.json")
+	opts := DefaultOptions()
+	opts.Durability = DurabilitySync
+	db, err := NewDBWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	user, err := db.CreateUser("author@example.com", "hash")
+	if err != nil {
+		t.Fatalf("creating user: %v", err)
+	}
+	seq := db.Feed().Seq()
+	sub, err := db.Feed().Subscribe(seq, 10)
+	if err != nil {
+		t.Fatalf("subscribing: %v", err)
+	}
+	defer sub.Close()
+	rename := func(email string) func(*DBStructure) error {
+		return func(dbStructure *DBStructure) error {
+			renamed := dbStructure.Users[user.ID]
+			renamed.Email = email
+			dbStructure.Users[user.ID] = renamed
+			return nil
+		}
+	}
+
+	errFailed := errors.New("failed")
+	tests := []struct {
+		name string
+		fn   func(*DBStructure) error
+		want error
+	}{
+		{"failing", func(dbStructure *DBStructure) error {
+			err := rename("failed@example.com")(dbStructure)
+			if err != nil {
+				return err
+			}
+			return errFailed
+		}, errFailed},
+		{"invalid", func(dbStructure *DBStructure) error {
+			err := rename("invalid@example.com")(dbStructure)
+			if err != nil {
+				return err
+			}
+			dbStructure.Sequences.Users = 0
+			return nil
+		}, ErrInvalidSnapshot},
+	}
+	for _, tt := range tests {
+		err := db.Update(tt.fn)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s update: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	// Writes fail while the WAL is closed underneath the database.
+	err = db.wal.Close()
+	if err != nil {
+		t.Fatalf("closing WAL: %v", err)
+	}
+	err = db.Update(rename("unwritten@example.com"))
+	if err == nil {
+		t.Error("updating with a closed WAL succeeded")
+	}
+	db.wal, err = os.OpenFile(walPath(path), os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatalf("reopening WAL: %v", err)
+	}
+	got, err := db.GetUser(user.ID)
+	if err != nil || got.Email != user.Email {
+		t.Fatalf("after the failed updates: got user %+v, %v, want it unchanged", got, err)
+	}
+	if got := db.Feed().Seq(); got != seq {
+		t.Errorf("feed is at %d after the failed updates, want %d", got, seq)
+	}
+
+	err = db.Update(rename("renamed@example.com"))
+	if err != nil {
+		t.Fatalf("updating: %v", err)
+	}
+	event := <-sub.C
+	if event.Kind != EventReset {
+		t.Errorf("got event %+v, want a reset", event)
+	}
+	crash(t, db)
+
+	// The update was written as a snapshot, so it survives a crash.
+	db, err = NewDBWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("reopening database: %v", err)
+	}
+	defer db.Close()
+	got, err = db.GetUserByEmail("renamed@example.com")
+	if err != nil || got.ID != user.ID {
+		t.Errorf("getting renamed user: got %+v, %v", got, err)
+	}
+}
+
+// TestWritesByMissingUser checks that likes and follows made on behalf of
+// a user who doesn't exist or is in the trash are refused, and that the
+// snapshot taken afterwards can still be restored.
+func TestWritesByMissingUser(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	defer db.Close()
+
+	author, err := db.CreateUser("author@example.com", "hash")
+	if err != nil {
+		t.Fatalf("creating user: %v", err)
+	}
+	trashed, err := db.CreateUser("trashed@example.com", "hash")
+	if err != nil {
+		t.Fatalf("creating user: %v", err)
+	}
+	chirp, err := db.CreateChirp(NewChirp{Body: "hello", AuthorID: author.ID})
+	if err != nil {
+		t.Fatalf("creating chirp: %v", err)
+	}
+	// The trashed user liked the chirp and followed the author before
+	// going to the trash.
+	err = db.LikeChirp(chirp.ID, trashed.ID)
+	if err != nil {
+		t.Fatalf("liking chirp: %v", err)
+	}
+	err = db.FollowUser(trashed.ID, author.ID)
+	if err != nil {
+		t.Fatalf("following: %v", err)
+	}
+	err = db.DeleteUser(trashed.ID)
+	if err != nil {
+		t.Fatalf("deleting user: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		write func(userID int) error
+	}{
+		{"liking", func(userID int) error { return db.LikeChirp(chirp.ID, userID) }},
+		{"unliking", func(userID int) error { return db.UnlikeChirp(chirp.ID, userID) }},
+		{"following", func(userID int) error { return db.FollowUser(userID, author.ID) }},
+		{"unfollowing", func(userID int) error { return db.UnfollowUser(userID, author.ID) }},
+	}
+	for _, tt := range tests {
+		for _, userID := range []int{99, trashed.ID} {
+			err := tt.write(userID)
+			if !errors.Is(err, ErrUnauthorized) {
+				t.Errorf("%s as user %d: got %v, want ErrUnauthorized", tt.name, userID, err)
+			}
+		}
+	}
+
+	dbStructure, err := db.Snapshot()
+	if err != nil {
+		t.Fatalf("reading snapshot: %v", err)
+	}
+	err = db.Restore(dbStructure)
+	if err != nil {
+		t.Errorf("restoring snapshot: %v", err)
+	}
+}
@@ -0,0 +1,201 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrLagged ends a subscription whose consumer fell so far behind that its
+// channel filled up. It can resubscribe after the last event it handled.
+var ErrLagged = errors.New("subscriber fell behind the change feed")
+
+// ErrFeedGap is returned when subscribing after a sequence number whose
+// following events are no longer retained.
+var ErrFeedGap = errors.New("change feed no longer holds the requested events")
+
+// feedHistory is how many recent events a Feed keeps for subscribers
+// resuming after a sequence number.
+const feedHistory = 1024
+
+type EventKind string
+
+const (
+	// EventCreate is published when a chirp or user appears: it was
+	// created, or restored from the trash.
+	EventCreate EventKind = "create"
+	// EventUpdate is published when a visible chirp or user changes.
+	EventUpdate EventKind = "update"
+	// EventDelete is published when a chirp or user disappears: it was
+	// moved to the trash or removed.
+	EventDelete EventKind = "delete"
+	// EventReset is published when the database was replaced or changed
+	// wholesale, e.g. by a restore. It carries no record; subscribers that
+	// keep derived state must rebuild it.
+	EventReset EventKind = "reset"
+)
+
+// Event describes one change. Exactly one of Chirp and User is set, to the
+// record's new value, or to its last value for EventDelete, except for
+// EventReset, which sets neither.
+type Event struct {
+	// Seq numbers events from 1 in the order their writes were made. It
+	// starts over when the process restarts.
+	Seq   uint64
+	Kind  EventKind
+	Chirp *Chirp
+	User  *User
+}
+
+// changeKind classifies a change by whether the record was visible, that
+// is stored and not in the trash, before and after it. Changes no reader
+// could see, such as purging a trashed record, are not events.
+func changeKind(before, after bool) (EventKind, bool) {
+	switch {
+	case !before && after:
+		return EventCreate, true
+	case before && after:
+		return EventUpdate, true
+	case before && !after:
+		return EventDelete, true
+	default:
+		return "", false
+	}
+}
+
+// Feed publishes the changes made to a store to in-process subscribers,
+// in the order the writes were made. Only writes that succeeded are
+// published, but when depends on the store: SQLite and the JSON store in
+// DurabilitySync mode publish once the write is on disk, while the JSON
+// store in DurabilityWriteBehind mode publishes once the change is applied
+// in memory and visible to readers, before the flusher writes it out. A
+// crash can then lose writes whose events were delivered. Publishing never
+// blocks the writer: a subscriber whose buffer is full is dropped with
+// ErrLagged.
+type Feed struct {
+	mu      sync.Mutex
+	seq     uint64
+	history []Event
+	subs    map[*Subscription]struct{}
+	closed  bool
+}
+
+func newFeed() *Feed {
+	return &Feed{
+		subs: map[*Subscription]struct{}{},
+	}
+}
+
+// Seq returns the sequence number of the latest event, 0 if there is none.
+// Subscribing after it delivers only events published from then on.
+func (f *Feed) Seq() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.seq
+}
+
+// Subscribe delivers every event with a sequence number above after, then
+// new events as they are published. Retained events past after are queued
+// straight away, on top of buffer slots for new ones. It returns
+// ErrFeedGap if some of the events past after are no longer retained.
+func (f *Feed) Subscribe(after uint64, buffer int) (*Subscription, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return nil, ErrClosed
+	}
+	if after > f.seq {
+		return nil, fmt.Errorf("sequence number %d is ahead of the change feed at %d", after, f.seq)
+	}
+	oldest := f.seq - uint64(len(f.history)) + 1
+	if after+1 < oldest {
+		return nil, ErrFeedGap
+	}
+
+	backlog := f.history[len(f.history)-int(f.seq-after):]
+	ch := make(chan Event, len(backlog)+max(buffer, 0))
+	for _, event := range backlog {
+		ch <- event
+	}
+	sub := &Subscription{
+		C:    ch,
+		ch:   ch,
+		feed: f,
+	}
+	f.subs[sub] = struct{}{}
+	return sub, nil
+}
+
+// publish numbers the events and hands them to every subscriber. Callers
+// must publish in the order their writes were made, which they ensure by
+// publishing under the lock that serializes those writes.
+func (f *Feed) publish(events ...Event) {
+	if len(events) == 0 {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, event := range events {
+		f.seq++
+		event.Seq = f.seq
+		f.history = append(f.history, event)
+		for sub := range f.subs {
+			select {
+			case sub.ch <- event:
+			default:
+				f.endLocked(sub, ErrLagged)
+			}
+		}
+	}
+	if len(f.history) > feedHistory {
+		f.history = f.history[len(f.history)-feedHistory:]
+	}
+}
+
+// close ends every subscription with ErrClosed and refuses new ones.
+func (f *Feed) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.closed = true
+	for sub := range f.subs {
+		f.endLocked(sub, ErrClosed)
+	}
+}
+
+func (f *Feed) endLocked(sub *Subscription, err error) {
+	delete(f.subs, sub)
+	sub.err = err
+	close(sub.ch)
+}
+
+// Subscription receives events from a Feed on C until C is closed.
+type Subscription struct {
+	C <-chan Event
+
+	ch   chan Event
+	feed *Feed
+	// err is why the subscription ended. It is guarded by feed.mu.
+	err error
+}
+
+// Err returns why C was closed: ErrLagged, ErrClosed, or nil after Close.
+func (s *Subscription) Err() error {
+	s.feed.mu.Lock()
+	defer s.feed.mu.Unlock()
+
+	return s.err
+}
+
+// Close ends the subscription. It is safe to call more than once.
+func (s *Subscription) Close() {
+	s.feed.mu.Lock()
+	defer s.feed.mu.Unlock()
+
+	if _, ok := s.feed.subs[s]; ok {
+		s.feed.endLocked(s, nil)
+	}
+}
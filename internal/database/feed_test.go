@@ -0,0 +1,170 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+// publishChirps publishes a create event for each of the chirp IDs.
+func publishChirps(f *Feed, ids ...int) {
+	for _, id := range ids {
+		f.publish(Event{Kind: EventCreate, Chirp: &Chirp{ID: id}})
+	}
+}
+
+// drain returns the sequence numbers of the events queued on sub, without
+// waiting for more.
+func drain(sub *Subscription) []uint64 {
+	seqs := []uint64{}
+	for {
+		select {
+		case event, ok := <-sub.C:
+			if !ok {
+				return seqs
+			}
+			seqs = append(seqs, event.Seq)
+		default:
+			return seqs
+		}
+	}
+}
+
+// equalSeqs reports whether a and b hold the same sequence numbers in order.
+func equalSeqs(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// TestFeedResumesAfterSeq checks that a subscription replays the retained
+// events after the requested sequence number before the new ones, and that
+// a sequence number the feed hasn't reached is refused.
+func TestFeedResumesAfterSeq(t *testing.T) {
+	f := newFeed()
+	publishChirps(f, 1, 2, 3, 4, 5)
+
+	sub, err := f.Subscribe(2, 10)
+	if err != nil {
+		t.Fatalf("subscribing after 2: %v", err)
+	}
+	defer sub.Close()
+	publishChirps(f, 6)
+
+	if got := drain(sub); !equalSeqs(got, []uint64{3, 4, 5, 6}) {
+		t.Errorf("resuming after 2: got %v, want 3 to 6", got)
+	}
+
+	// Subscribing at the latest sequence number delivers only new events.
+	latest, err := f.Subscribe(f.Seq(), 10)
+	if err != nil {
+		t.Fatalf("subscribing at the latest event: %v", err)
+	}
+	defer latest.Close()
+	if got := drain(latest); len(got) != 0 {
+		t.Errorf("subscribing at the latest event: got %v before publishing", got)
+	}
+	publishChirps(f, 7)
+	if got := drain(latest); !equalSeqs(got, []uint64{7}) {
+		t.Errorf("subscribing at the latest event: got %v, want 7", got)
+	}
+
+	_, err = f.Subscribe(f.Seq()+1, 10)
+	if err == nil {
+		t.Error("subscribing ahead of the feed succeeded")
+	}
+}
+
+// TestFeedLaggedSubscriberIsDropped checks that a subscriber whose buffer
+// fills is ended with ErrLagged without holding up the others, and can
+// resume from the last event it received.
+func TestFeedLaggedSubscriberIsDropped(t *testing.T) {
+	f := newFeed()
+	sub, err := f.Subscribe(0, 2)
+	if err != nil {
+		t.Fatalf("subscribing: %v", err)
+	}
+	other, err := f.Subscribe(0, 10)
+	if err != nil {
+		t.Fatalf("subscribing: %v", err)
+	}
+	defer other.Close()
+
+	// The third event doesn't fit the buffer of two.
+	publishChirps(f, 1, 2, 3)
+	if got := drain(sub); !equalSeqs(got, []uint64{1, 2}) {
+		t.Errorf("lagged subscriber got %v, want 1 and 2", got)
+	}
+	if !errors.Is(sub.Err(), ErrLagged) {
+		t.Errorf("lagged subscriber ended with %v, want ErrLagged", sub.Err())
+	}
+	// Publishing carried on for everyone else.
+	if got := drain(other); !equalSeqs(got, []uint64{1, 2, 3}) {
+		t.Errorf("other subscriber got %v, want 1 to 3", got)
+	}
+
+	// The lagged subscriber resumes after the last event it handled.
+	resumed, err := f.Subscribe(2, 2)
+	if err != nil {
+		t.Fatalf("resubscribing after 2: %v", err)
+	}
+	defer resumed.Close()
+	if got := drain(resumed); !equalSeqs(got, []uint64{3}) {
+		t.Errorf("resumed subscriber got %v, want 3", got)
+	}
+}
+
+// TestFeedGapPastHistory checks that resuming from before the oldest event
+// the feed still holds fails with ErrFeedGap rather than skipping events.
+func TestFeedGapPastHistory(t *testing.T) {
+	f := newFeed()
+	for id := 1; id <= feedHistory+10; id++ {
+		publishChirps(f, id)
+	}
+
+	// Events 1 to 10 have been dropped from the history.
+	for _, after := range []uint64{0, 5, 9} {
+		_, err := f.Subscribe(after, 0)
+		if !errors.Is(err, ErrFeedGap) {
+			t.Errorf("subscribing after %d: got %v, want ErrFeedGap", after, err)
+		}
+	}
+
+	// Resuming just before the oldest retained event still works.
+	sub, err := f.Subscribe(10, 0)
+	if err != nil {
+		t.Fatalf("subscribing after 10: %v", err)
+	}
+	defer sub.Close()
+	got := drain(sub)
+	if len(got) != feedHistory || got[0] != 11 || got[len(got)-1] != feedHistory+10 {
+		t.Errorf("subscribing after 10: got %d events, want %d from 11 on", len(got), feedHistory)
+	}
+}
+
+// TestFeedCloseEndsSubscriptions checks that closing the feed ends its
+// subscriptions with ErrClosed and refuses new ones.
+func TestFeedCloseEndsSubscriptions(t *testing.T) {
+	f := newFeed()
+	sub, err := f.Subscribe(0, 1)
+	if err != nil {
+		t.Fatalf("subscribing: %v", err)
+	}
+	f.close()
+
+	if _, ok := <-sub.C; ok {
+		t.Error("subscription delivered an event after the feed closed")
+	}
+	if !errors.Is(sub.Err(), ErrClosed) {
+		t.Errorf("subscription ended with %v, want ErrClosed", sub.Err())
+	}
+	_, err = f.Subscribe(0, 1)
+	if !errors.Is(err, ErrClosed) {
+		t.Errorf("subscribing to a closed feed: got %v, want ErrClosed", err)
+	}
+}
@@ -0,0 +1,95 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// writeFileAtomic replaces path with dat so that readers, and the file
+// after a crash, see either the old or the new contents but never a
+// partial write: the data goes to a temp file in the same directory, which
+// is fsynced and then renamed over path.
+func writeFileAtomic(path string, dat []byte) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	_, err = tmp.Write(dat)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Sync()
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir flushes a directory entry change, such as a rename, to disk.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// generationPath returns the name of the n-th previous version of path.
+func generationPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// rotateGenerations shifts path.1 .. path.(keep-1) up by one, dropping the
+// oldest, and hard-links the current path as path.1. It is called just
+// before path is replaced, so path.1 always holds the previous version.
+func rotateGenerations(path string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+
+	err := os.Remove(generationPath(path, keep))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for n := keep - 1; n >= 1; n-- {
+		err := os.Rename(generationPath(path, n), generationPath(path, n+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	err = os.Link(path, generationPath(path, 1))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// removeGenerations deletes every kept previous version of path.
+func removeGenerations(path string, keep int) error {
+	for n := 1; n <= keep; n++ {
+		err := os.Remove(generationPath(path, n))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
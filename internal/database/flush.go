@@ -0,0 +1,218 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+)
+
+// runFlusher writes pending WAL entries every interval until Close stops
+// it.
+func (db *DB) runFlusher(interval time.Duration) {
+	defer db.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-db.stop:
+			return
+		}
+		err := db.Flush()
+		if err != nil {
+			log.Printf("Couldn't flush database: %s", err)
+		}
+	}
+}
+
+// runCompactor folds the WAL into a new snapshot whenever asked through
+// requestCompaction, until Close stops it.
+func (db *DB) runCompactor() {
+	defer db.wg.Done()
+
+	for {
+		select {
+		case <-db.compact:
+		case <-db.stop:
+			return
+		}
+		err := db.Compact()
+		if err != nil {
+			log.Printf("Couldn't compact database: %s", err)
+		}
+	}
+}
+
+func (db *DB) requestCompaction() {
+	select {
+	case db.compact <- struct{}{}:
+	default:
+	}
+}
+
+// Flush appends the pending WAL entries to the log, or writes a full
+// snapshot if one is due.
+func (db *DB) Flush() error {
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	db.mu.Lock()
+	db.flushMu.Lock()
+	if db.snapshotDue {
+		db.flushMu.Unlock()
+		defer db.mu.Unlock()
+		return db.compactLocked()
+	}
+	entries := db.pending
+	db.pending = nil
+	db.mu.Unlock()
+	defer db.flushMu.Unlock()
+
+	return db.appendPending(entries)
+}
+
+// flushLocked is Flush for callers that already hold db.mu.
+func (db *DB) flushLocked() error {
+	db.flushMu.Lock()
+	defer db.flushMu.Unlock()
+
+	if db.snapshotDue {
+		return db.writeSnapshotLocked()
+	}
+	entries := db.pending
+	db.pending = nil
+	return db.appendPending(entries)
+}
+
+// appendPending writes entries after any left over from a failed append,
+// keeping them all for the next attempt if this one fails too. In
+// DurabilitySync mode they aren't kept, since the update that made them is
+// rolled back instead. The caller must hold db.flushMu.
+func (db *DB) appendPending(entries []walEntry) error {
+	entries = append(db.unwritten, entries...)
+	err := db.appendWAL(entries)
+	if err != nil {
+		if db.durability == DurabilityWriteBehind {
+			db.unwritten = entries
+		}
+		return err
+	}
+	db.unwritten = nil
+	return nil
+}
+
+// Compact writes a snapshot of the current state and empties the WAL. The
+// state is encoded under the lock; the file is written without holding it.
+func (db *DB) Compact() error {
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	db.mu.Lock()
+	dat, err := json.Marshal(db.data)
+	if err != nil {
+		db.mu.Unlock()
+		return err
+	}
+	db.pending = nil
+	db.flushMu.Lock()
+	db.mu.Unlock()
+	defer db.flushMu.Unlock()
+
+	return db.writeSnapshot(dat)
+}
+
+// compactLocked is Compact for callers that already hold db.mu.
+func (db *DB) compactLocked() error {
+	db.flushMu.Lock()
+	defer db.flushMu.Unlock()
+
+	return db.writeSnapshotLocked()
+}
+
+// writeSnapshotLocked encodes and writes the current state. The caller
+// must hold both db.mu and db.flushMu.
+func (db *DB) writeSnapshotLocked() error {
+	dat, err := json.Marshal(db.data)
+	if err != nil {
+		return err
+	}
+	db.pending = nil
+	return db.writeSnapshot(dat)
+}
+
+// writeSnapshot replaces the snapshot with dat and empties the WAL, whose
+// entries dat already includes. If the snapshot can't be written the WAL
+// no longer matches memory, so another snapshot is marked due. The caller
+// must hold db.flushMu.
+func (db *DB) writeSnapshot(dat []byte) error {
+	db.unwritten = nil
+	dat = encodeFile(db.cipher, dat)
+	sum := fileChecksum(dat)
+
+	// Mark the snapshot in the WAL first, so that if the WAL isn't emptied
+	// after the snapshot is written, loading skips the entries before it.
+	// The mark is taken back if the snapshot isn't written.
+	unmarkedSize := db.walSize
+	err := db.appendWAL([]walEntry{snapshotMark(sum)})
+	if err != nil {
+		db.snapshotDue = true
+		return err
+	}
+
+	err = db.dataLock.lock(true, db.lockTimeout)
+	if err != nil {
+		db.snapshotDue = true
+		return err
+	}
+	defer db.dataLock.unlock()
+
+	if !db.primaryDamaged {
+		err := rotateGenerations(db.path, db.generations)
+		if err != nil {
+			db.snapshotDue = true
+			return errors.Join(err, db.truncateWAL(unmarkedSize))
+		}
+	}
+
+	err = writeFileAtomic(db.path, dat)
+	if err != nil {
+		db.snapshotDue = true
+		return errors.Join(err, db.truncateWAL(unmarkedSize))
+	}
+	db.primaryDamaged = false
+	db.snapshotDue = false
+
+	// The snapshot holds every change now, and the WAL ends with its mark,
+	// so a WAL that can't be emptied is only replayed from there.
+	err = db.resetWAL(sum)
+	if err != nil {
+		log.Printf("Couldn't empty %s: %s", walPath(db.path), err)
+	}
+	return nil
+}
+
+// Close stops the background flusher and compactor, writes a final
+// snapshot with every pending change and releases the locks. Mutations
+// after Close fail with ErrClosed, and feed subscriptions end.
+func (db *DB) Close() error {
+	db.mu.Lock()
+	if db.closed {
+		db.mu.Unlock()
+		return nil
+	}
+	db.closed = true
+	db.mu.Unlock()
+	db.feed.close()
+
+	if db.readOnly {
+		return db.closeLocks()
+	}
+
+	close(db.stop)
+	db.wg.Wait()
+
+	err := db.Compact()
+	return errors.Join(err, db.wal.Close(), db.closeLocks())
+}
@@ -0,0 +1,104 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrSelfFollow is returned when a user tries to follow themselves.
+var ErrSelfFollow = errors.New("users can't follow themselves")
+
+// Follow records that a user follows another.
+type Follow struct {
+	FollowerID int `json:"follower_id"`
+	FolloweeID int `json:"followee_id"`
+	// CreatedAt is when the follow started, in UTC.
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// FollowSummary is what a user's place in the follow graph looks like to
+// another user.
+type FollowSummary struct {
+	// Followers and Following count the users following the user and the
+	// users they follow, leaving out users in the trash.
+	Followers int
+	Following int
+	// FollowedByViewer reports whether the user asked about follows the
+	// user.
+	FollowedByViewer bool
+}
+
+// FollowUser makes the follower follow the followee. Following a user
+// again changes nothing. It returns ErrSelfFollow if they are the same
+// user, ErrUnauthorized if the follower doesn't exist or is in the trash,
+// and ErrNotExist if the followee doesn't exist or is in the trash.
+func (db *DB) FollowUser(followerID, followeeID int) error {
+	if followerID == followeeID {
+		return ErrSelfFollow
+	}
+	return db.update(func(t *tx) error {
+		err := t.activeUser(followerID)
+		if err != nil {
+			return err
+		}
+		followee, ok := t.data.Users[followeeID]
+		if !ok || followee.DeletedAt != nil {
+			return ErrNotExist
+		}
+		if _, ok := t.data.Follows[followerID][followeeID]; ok {
+			return nil
+		}
+		t.follow(Follow{
+			FollowerID: followerID,
+			FolloweeID: followeeID,
+			CreatedAt:  time.Now().UTC(),
+		}, db.fanoutThreshold)
+		return nil
+	})
+}
+
+// UnfollowUser stops the follower following the followee, if they do. It
+// returns ErrUnauthorized and ErrNotExist like FollowUser.
+func (db *DB) UnfollowUser(followerID, followeeID int) error {
+	return db.update(func(t *tx) error {
+		err := t.activeUser(followerID)
+		if err != nil {
+			return err
+		}
+		followee, ok := t.data.Users[followeeID]
+		if !ok || followee.DeletedAt != nil {
+			return ErrNotExist
+		}
+		t.unfollow(followerID, followeeID, db.fanoutThreshold)
+		return nil
+	})
+}
+
+// GetFollowSummary counts the user's followers and followings and tells
+// whether viewerID, 0 for nobody, follows them. It returns ErrNotExist if
+// the user doesn't exist or is in the trash.
+func (db *DB) GetFollowSummary(userID, viewerID int) (FollowSummary, error) {
+	summary := FollowSummary{}
+	err := db.View(func(dbStructure DBStructure) error {
+		user, ok := dbStructure.Users[userID]
+		if !ok || user.DeletedAt != nil {
+			return ErrNotExist
+		}
+		for _, followerID := range db.idx.followers[userID] {
+			if dbStructure.Users[followerID].DeletedAt == nil {
+				summary.Followers++
+			}
+		}
+		for followeeID := range dbStructure.Follows[userID] {
+			if dbStructure.Users[followeeID].DeletedAt == nil {
+				summary.Following++
+			}
+		}
+		_, summary.FollowedByViewer = dbStructure.Follows[viewerID][userID]
+		return nil
+	})
+	if err != nil {
+		return FollowSummary{}, err
+	}
+	return summary, nil
+}
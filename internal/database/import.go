@@ -0,0 +1,57 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+var ErrNotEmpty = errors.New("target database is not empty")
+
+// ImportJSON copies the users, chirps and refresh tokens from a JSON
+// database file, including its write-ahead log, into dst, keeping their
+// IDs. The JSON file is opened read-only with opts, e.g. for its Cipher, so
+// a server may keep using it. It runs in one transaction and refuses to
+// import into a database that already holds users or chirps.
+func ImportJSON(jsonPath string, opts Options, dst *SQLiteDB) error {
+	_, err := os.Stat(jsonPath)
+	if err != nil {
+		return err
+	}
+	opts.ReadOnly = true
+	src, err := NewDBWithOptions(jsonPath, opts)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", jsonPath, err)
+	}
+	defer src.Close()
+
+	dbStructure, err := src.Snapshot()
+	if err != nil {
+		return err
+	}
+	err = dbStructure.Validate()
+	if err != nil {
+		return err
+	}
+
+	tx, err := dst.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var existing int
+	err = tx.QueryRow(`SELECT (SELECT COUNT(*) FROM users) + (SELECT COUNT(*) FROM chirps)`).Scan(&existing)
+	if err != nil {
+		return err
+	}
+	if existing > 0 {
+		return ErrNotEmpty
+	}
+
+	err = insertStructure(tx, dbStructure)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
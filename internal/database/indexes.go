@@ -0,0 +1,174 @@
+package database
+
+import (
+	"sort"
+	"strings"
+)
+
+// indexes are in-memory secondary indexes over DBStructure. They are not
+// persisted; they are rebuilt whenever the structure is loaded or replaced
+// and kept current by the methods that mutate it. They are guarded by
+// DB.mu like the data they index.
+type indexes struct {
+	// userByEmail maps a lowercased email to the user's ID. Trashed users
+	// stay in it, as they keep their email reserved.
+	userByEmail map[string]int
+	// chirpIDs holds the IDs of the chirps that are not in the trash, in
+	// ascending order.
+	chirpIDs []int
+	// chirpsByAuthor maps an author ID to the IDs of their chirps that are
+	// not in the trash, in ascending order.
+	chirpsByAuthor map[int][]int
+	// repliesByParent maps a chirp ID to the IDs of the replies to it, in
+	// ascending order. Unlike the other chirp indexes it includes trashed
+	// chirps, which stay in threads while they have replies.
+	repliesByParent map[int][]int
+	// followers maps a user ID to the IDs of their followers, in
+	// ascending order, the reverse of DBStructure.Follows.
+	followers map[int][]int
+	// search is the full-text index over the bodies of the chirps that
+	// are not in the trash.
+	search *searchIndex
+}
+
+func buildIndexes(dbStructure *DBStructure) indexes {
+	idx := indexes{
+		userByEmail:     make(map[string]int, len(dbStructure.Users)),
+		chirpIDs:        make([]int, 0, len(dbStructure.Chirps)),
+		chirpsByAuthor:  map[int][]int{},
+		repliesByParent: map[int][]int{},
+		followers:       map[int][]int{},
+		search:          buildSearchIndex(dbStructure.Chirps),
+	}
+	for _, user := range dbStructure.Users {
+		idx.addUser(user)
+	}
+	// Appending and sorting once is cheaper than inserting each ID in
+	// order.
+	for _, chirp := range dbStructure.Chirps {
+		if chirp.InReplyTo != 0 {
+			idx.repliesByParent[chirp.InReplyTo] = append(idx.repliesByParent[chirp.InReplyTo], chirp.ID)
+		}
+		if chirp.DeletedAt != nil {
+			continue
+		}
+		idx.chirpIDs = append(idx.chirpIDs, chirp.ID)
+		idx.chirpsByAuthor[chirp.AuthorID] = append(idx.chirpsByAuthor[chirp.AuthorID], chirp.ID)
+	}
+	for followerID, following := range dbStructure.Follows {
+		for followeeID := range following {
+			idx.followers[followeeID] = append(idx.followers[followeeID], followerID)
+		}
+	}
+	for _, ids := range idx.followers {
+		sort.Ints(ids)
+	}
+	sort.Ints(idx.chirpIDs)
+	for _, ids := range idx.chirpsByAuthor {
+		sort.Ints(ids)
+	}
+	for _, ids := range idx.repliesByParent {
+		sort.Ints(ids)
+	}
+	return idx
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func (idx *indexes) addUser(user User) {
+	idx.userByEmail[normalizeEmail(user.Email)] = user.ID
+}
+
+func (idx *indexes) removeUser(user User) {
+	key := normalizeEmail(user.Email)
+	if idx.userByEmail[key] == user.ID {
+		delete(idx.userByEmail, key)
+	}
+}
+
+func (idx *indexes) addChirp(chirp Chirp) {
+	if chirp.InReplyTo != 0 {
+		idx.repliesByParent[chirp.InReplyTo] = insertID(idx.repliesByParent[chirp.InReplyTo], chirp.ID)
+	}
+	if chirp.DeletedAt != nil {
+		return
+	}
+	idx.chirpIDs = insertID(idx.chirpIDs, chirp.ID)
+	idx.chirpsByAuthor[chirp.AuthorID] = insertID(idx.chirpsByAuthor[chirp.AuthorID], chirp.ID)
+	idx.search.add(chirp)
+}
+
+func (idx *indexes) removeChirp(chirp Chirp) {
+	idx.search.remove(chirp.ID)
+	if chirp.InReplyTo != 0 {
+		replies := removeID(idx.repliesByParent[chirp.InReplyTo], chirp.ID)
+		if len(replies) == 0 {
+			delete(idx.repliesByParent, chirp.InReplyTo)
+		} else {
+			idx.repliesByParent[chirp.InReplyTo] = replies
+		}
+	}
+	idx.chirpIDs = removeID(idx.chirpIDs, chirp.ID)
+	ids := removeID(idx.chirpsByAuthor[chirp.AuthorID], chirp.ID)
+	if len(ids) == 0 {
+		delete(idx.chirpsByAuthor, chirp.AuthorID)
+	} else {
+		idx.chirpsByAuthor[chirp.AuthorID] = ids
+	}
+}
+
+func (idx *indexes) addFollow(followerID, followeeID int) {
+	idx.followers[followeeID] = insertID(idx.followers[followeeID], followerID)
+}
+
+func (idx *indexes) removeFollow(followerID, followeeID int) {
+	followers := removeID(idx.followers[followeeID], followerID)
+	if len(followers) == 0 {
+		delete(idx.followers, followeeID)
+	} else {
+		idx.followers[followeeID] = followers
+	}
+}
+
+// insertID adds id to the ascending slice ids unless it is already there.
+// New chirps have the highest ID so far, which makes this an append.
+func insertID(ids []int, id int) []int {
+	i := sort.SearchInts(ids, id)
+	if i < len(ids) && ids[i] == id {
+		return ids
+	}
+	ids = append(ids, 0)
+	copy(ids[i+1:], ids[i:])
+	ids[i] = id
+	return ids
+}
+
+// removeID removes id from the ascending slice ids if it is there.
+func removeID(ids []int, id int) []int {
+	i := sort.SearchInts(ids, id)
+	if i == len(ids) || ids[i] != id {
+		return ids
+	}
+	return append(ids[:i], ids[i+1:]...)
+}
+
+// chirpsByAuthors returns the IDs of the given authors' chirps that are
+// not in the trash, in ascending order.
+func (idx *indexes) chirpsByAuthors(authorIDs []int) []int {
+	if len(authorIDs) == 1 {
+		return idx.chirpsByAuthor[authorIDs[0]]
+	}
+	seen := map[int]bool{}
+	ids := []int{}
+	for _, authorID := range authorIDs {
+		if seen[authorID] {
+			continue
+		}
+		seen[authorID] = true
+		ids = append(ids, idx.chirpsByAuthor[authorID]...)
+	}
+	sort.Ints(ids)
+	return ids
+}
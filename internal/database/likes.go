@@ -0,0 +1,145 @@
+package database
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Like records that a user liked a chirp.
+type Like struct {
+	ChirpID int `json:"chirp_id"`
+	UserID  int `json:"user_id"`
+	// CreatedAt is when the user liked the chirp, in UTC.
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LikeSummary is what a chirp's likes look like to one user.
+type LikeSummary struct {
+	Count int
+	// LikedByViewer reports whether the user asked about liked the chirp.
+	LikedByViewer bool
+}
+
+// pairKey is the WAL key of a record identified by two IDs, such as a
+// like by its chirp and user.
+func pairKey(first, second int) string {
+	return strconv.Itoa(first) + ":" + strconv.Itoa(second)
+}
+
+func parsePairKey(key string) (first, second int, err error) {
+	firstStr, secondStr, _ := strings.Cut(key, ":")
+	first, err = strconv.Atoi(firstStr)
+	if err != nil {
+		return 0, 0, err
+	}
+	second, err = strconv.Atoi(secondStr)
+	if err != nil {
+		return 0, 0, err
+	}
+	return first, second, nil
+}
+
+// LikeChirp records that the user likes the chirp. Liking a chirp again
+// changes nothing. It returns ErrUnauthorized if the user doesn't exist or
+// is in the trash, and ErrNotExist if the chirp doesn't exist or is in the
+// trash.
+func (db *DB) LikeChirp(chirpID, userID int) error {
+	return db.update(func(t *tx) error {
+		err := t.activeUser(userID)
+		if err != nil {
+			return err
+		}
+		chirp, ok := t.data.Chirps[chirpID]
+		if !ok || chirp.DeletedAt != nil {
+			return ErrNotExist
+		}
+		if _, ok := t.data.Likes[chirpID][userID]; ok {
+			return nil
+		}
+		t.putLike(Like{
+			ChirpID:   chirpID,
+			UserID:    userID,
+			CreatedAt: time.Now().UTC(),
+		})
+		return nil
+	})
+}
+
+// UnlikeChirp removes the user's like of the chirp, if there is one. It
+// returns the same errors as LikeChirp.
+func (db *DB) UnlikeChirp(chirpID, userID int) error {
+	return db.update(func(t *tx) error {
+		err := t.activeUser(userID)
+		if err != nil {
+			return err
+		}
+		chirp, ok := t.data.Chirps[chirpID]
+		if !ok || chirp.DeletedAt != nil {
+			return ErrNotExist
+		}
+		t.deleteLike(chirpID, userID)
+		return nil
+	})
+}
+
+// GetLikes returns the likes of the chirp, newest first. It returns
+// ErrNotExist if the chirp doesn't exist or is in the trash.
+func (db *DB) GetLikes(chirpID int) ([]Like, error) {
+	likes := []Like{}
+	err := db.View(func(dbStructure DBStructure) error {
+		chirp, ok := dbStructure.Chirps[chirpID]
+		if !ok || chirp.DeletedAt != nil {
+			return ErrNotExist
+		}
+		for userID, createdAt := range dbStructure.Likes[chirpID] {
+			likes = append(likes, Like{
+				ChirpID:   chirpID,
+				UserID:    userID,
+				CreatedAt: createdAt,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortLikes(likes)
+	return likes, nil
+}
+
+// sortLikes orders likes newest first, breaking ties by user ID.
+func sortLikes(likes []Like) {
+	sort.Slice(likes, func(i, j int) bool {
+		if !likes[i].CreatedAt.Equal(likes[j].CreatedAt) {
+			return likes[i].CreatedAt.After(likes[j].CreatedAt)
+		}
+		return likes[i].UserID < likes[j].UserID
+	})
+}
+
+// GetLikeSummaries returns how many likes each of the chirps has and
+// whether viewerID, 0 for nobody, is among them. Chirps without likes are
+// left out of the map.
+func (db *DB) GetLikeSummaries(chirpIDs []int, viewerID int) (map[int]LikeSummary, error) {
+	summaries := map[int]LikeSummary{}
+	err := db.View(func(dbStructure DBStructure) error {
+		for _, chirpID := range chirpIDs {
+			likes := dbStructure.Likes[chirpID]
+			if len(likes) == 0 {
+				continue
+			}
+			_, liked := likes[viewerID]
+			summaries[chirpID] = LikeSummary{
+				Count:         len(likes),
+				LikedByViewer: liked,
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return summaries, nil
+}
@@ -0,0 +1,111 @@
+package database
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// ErrLockTimeout is returned when another process holds a lock on the
+// database for longer than Options.LockTimeout.
+var ErrLockTimeout = errors.New("timed out waiting for the database lock; is another chirpy process using it?")
+
+// ErrReadOnly is returned by mutations on a database opened read-only.
+var ErrReadOnly = errors.New("database is opened read-only")
+
+const lockPollInterval = 20 * time.Millisecond
+
+// A JSON database uses two advisory lock files next to it:
+//
+//   - path.writer.lock is held exclusively for the whole lifetime of a
+//     writable DB. The DB serves its cached state from memory, so a second
+//     writer, even one that only writes briefly, would be overwritten by
+//     it or overwrite it.
+//   - path.lock guards the files themselves: it is held shared while a DB
+//     loads the snapshot and WAL, and exclusively while the writer changes
+//     them, so read-only tooling always loads a consistent state.
+
+func writerLockPath(path string) string {
+	return path + ".writer.lock"
+}
+
+func dataLockPath(path string) string {
+	return path + ".lock"
+}
+
+// fileLock is an open lock file that can be locked and unlocked
+// repeatedly.
+type fileLock struct {
+	f *os.File
+}
+
+func openLockFile(path string) (*fileLock, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &fileLock{f: f}, nil
+}
+
+// lock takes the lock, shared or exclusive, waiting up to timeout for
+// other processes to release it.
+func (l *fileLock) lock(exclusive bool, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		acquired, err := l.tryLock(exclusive)
+		if err != nil {
+			return err
+		}
+		if acquired {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return ErrLockTimeout
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+func (l *fileLock) close() error {
+	return l.f.Close()
+}
+
+// openLocks opens the lock files and, for a writable DB, takes the writer
+// lock.
+func (db *DB) openLocks() error {
+	dataLock, err := openLockFile(dataLockPath(db.path))
+	if err != nil {
+		return err
+	}
+	db.dataLock = dataLock
+	if db.readOnly {
+		return nil
+	}
+
+	writerLock, err := openLockFile(writerLockPath(db.path))
+	if err != nil {
+		db.closeLocks()
+		return err
+	}
+	db.writerLock = writerLock
+	err = writerLock.lock(true, db.lockTimeout)
+	if err != nil {
+		db.closeLocks()
+		return err
+	}
+	return nil
+}
+
+// closeLocks releases every lock the DB holds by closing the lock files.
+func (db *DB) closeLocks() error {
+	var errs []error
+	if db.writerLock != nil {
+		errs = append(errs, db.writerLock.close())
+		db.writerLock = nil
+	}
+	if db.dataLock != nil {
+		errs = append(errs, db.dataLock.close())
+		db.dataLock = nil
+	}
+	return errors.Join(errs...)
+}
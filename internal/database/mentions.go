@@ -0,0 +1,136 @@
+package database
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Mention is a user mentioned in a chirp's body. Start and End are the
+// offsets of the mention, from its @ up to but not including the next
+// character, counted in characters (runes) rather than bytes.
+type Mention struct {
+	UserID int `json:"user_id"`
+	Start  int `json:"start"`
+	End    int `json:"end"`
+}
+
+// Notification tells a user that a chirp mentions them. A chirp notifies
+// each user it mentions once, except its author.
+type Notification struct {
+	UserID  int `json:"user_id"`
+	ChirpID int `json:"chirp_id"`
+	// CreatedAt is when the chirp first mentioned the user, and ReadAt is
+	// set once the user has marked their mentions read. Both are in UTC.
+	CreatedAt time.Time  `json:"created_at"`
+	ReadAt    *time.Time `json:"read_at,omitempty"`
+}
+
+// MentionPage is a page of the chirps mentioning a user, newest first.
+type MentionPage struct {
+	Chirps []Chirp
+	// More reports whether more chirps follow the page.
+	More bool
+	// Unread counts the user's unread mentions, on every page.
+	Unread int
+}
+
+// mentionedUsers returns the IDs of the users the chirp notifies: those it
+// mentions, other than its author.
+func mentionedUsers(chirp Chirp) map[int]bool {
+	users := map[int]bool{}
+	for _, mention := range chirp.Mentions {
+		if mention.UserID != chirp.AuthorID {
+			users[mention.UserID] = true
+		}
+	}
+	return users
+}
+
+// notifyMentions brings the notifications of a chirp that was posted or
+// edited in line with its mentions. before is the chirp as it was before
+// an edit, and zero for a new chirp. Users it no longer mentions lose
+// their notification, users it mentions for the first time get one, and
+// users still mentioned keep theirs, read or not. Users who don't exist or
+// are in the trash aren't notified.
+func (t *tx) notifyMentions(chirp, before Chirp) {
+	users := mentionedUsers(chirp)
+	for userID := range mentionedUsers(before) {
+		if !users[userID] {
+			t.deleteNotification(userID, chirp.ID)
+		}
+	}
+
+	now := time.Now().UTC()
+	for userID := range users {
+		user, ok := t.data.Users[userID]
+		if !ok || user.DeletedAt != nil {
+			continue
+		}
+		if _, ok := t.data.Notifications[userID][chirp.ID]; ok {
+			continue
+		}
+		t.putNotification(Notification{
+			UserID:    userID,
+			ChirpID:   chirp.ID,
+			CreatedAt: now,
+		})
+	}
+}
+
+// GetMentions returns a page of the chirps mentioning the user, newest
+// first, starting after the chirp with ID afterID if it isn't 0. Chirps in
+// the trash are left out, and don't count as unread.
+func (db *DB) GetMentions(userID, afterID, limit int) (MentionPage, error) {
+	page := MentionPage{
+		Chirps: []Chirp{},
+	}
+	err := db.View(func(dbStructure DBStructure) error {
+		ids := make([]int, 0, len(dbStructure.Notifications[userID]))
+		for chirpID, notification := range dbStructure.Notifications[userID] {
+			if dbStructure.Chirps[chirpID].DeletedAt != nil {
+				continue
+			}
+			ids = append(ids, chirpID)
+			if notification.ReadAt == nil {
+				page.Unread++
+			}
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(ids)))
+
+		before := afterID
+		if before == 0 {
+			before = math.MaxInt
+		}
+		for _, id := range ids {
+			if id >= before {
+				continue
+			}
+			if limit > 0 && len(page.Chirps) == limit {
+				page.More = true
+				break
+			}
+			page.Chirps = append(page.Chirps, dbStructure.Chirps[id])
+		}
+		return nil
+	})
+	if err != nil {
+		return MentionPage{}, err
+	}
+	return page, nil
+}
+
+// MarkMentionsRead marks all of the user's mentions read.
+func (db *DB) MarkMentionsRead(userID int) error {
+	return db.update(func(t *tx) error {
+		now := time.Now().UTC()
+		for _, notification := range t.data.Notifications[userID] {
+			if notification.ReadAt != nil {
+				continue
+			}
+			notification.ReadAt = &now
+			t.putNotification(notification)
+		}
+		return nil
+	})
+}
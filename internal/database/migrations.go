@@ -0,0 +1,114 @@
+package database
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+// loadMigrations reads the embedded migration files. Files are named
+// NNNN_description.sql and are applied in ascending version order.
+func loadMigrations() ([]migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]migration, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		prefix, _, ok := strings.Cut(name, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %q: missing version prefix", name)
+		}
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("migration %q: invalid version: %w", name, err)
+		}
+		dat, err := migrationFiles.ReadFile(path.Join("migrations", name))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{
+			version: version,
+			name:    strings.TrimSuffix(name, ".sql"),
+			sql:     string(dat),
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].version == migrations[i-1].version {
+			return nil, fmt.Errorf("duplicate migration version %d", migrations[i].version)
+		}
+	}
+	return migrations, nil
+}
+
+// migrate applies every migration newer than the recorded schema version.
+// Migrations are forward-only; each one runs in its own transaction.
+func migrate(conn *sql.DB) error {
+	_, err := conn.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER  PRIMARY KEY,
+		name       TEXT     NOT NULL,
+		applied_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return err
+	}
+
+	var current int
+	err = conn.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current)
+	if err != nil {
+		return err
+	}
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		err := applyMigration(conn, m)
+		if err != nil {
+			return fmt.Errorf("migration %s: %w", m.name, err)
+		}
+	}
+	return nil
+}
+
+func applyMigration(conn *sql.DB, m migration) error {
+	tx, err := conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(m.sql)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO schema_migrations (version, name) VALUES (?, ?)`, m.version, m.name)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
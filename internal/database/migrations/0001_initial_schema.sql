@@ -0,0 +1,20 @@
+CREATE TABLE users (
+	id              INTEGER PRIMARY KEY AUTOINCREMENT,
+	email           TEXT    NOT NULL UNIQUE,
+	hashed_password TEXT    NOT NULL,
+	is_chirpy_red   BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE TABLE chirps (
+	id        INTEGER PRIMARY KEY AUTOINCREMENT,
+	body      TEXT    NOT NULL,
+	author_id INTEGER NOT NULL
+);
+
+CREATE INDEX chirps_author_id ON chirps (author_id);
+
+CREATE TABLE refresh_tokens (
+	token      TEXT     PRIMARY KEY,
+	user_id    INTEGER  NOT NULL,
+	expires_at DATETIME NOT NULL
+);
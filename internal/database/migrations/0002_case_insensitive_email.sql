@@ -0,0 +1,3 @@
+-- Emails are matched case-insensitively. The index both enforces that and
+-- serves lookups written as "email = ? COLLATE NOCASE".
+CREATE UNIQUE INDEX users_email_nocase ON users (email COLLATE NOCASE);
@@ -0,0 +1,4 @@
+-- Deleted chirps and users are kept, with the time they were deleted,
+-- until the trash is purged.
+ALTER TABLE chirps ADD COLUMN deleted_at DATETIME;
+ALTER TABLE users ADD COLUMN deleted_at DATETIME;
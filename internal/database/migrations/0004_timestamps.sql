@@ -0,0 +1,11 @@
+-- Chirps and users record when they were created and last changed.
+-- SQLite can't add NOT NULL columns without a constant default, so the
+-- columns are nullable and every insert sets them. Existing rows get the
+-- time of the migration, the latest they can have been created at.
+ALTER TABLE chirps ADD COLUMN created_at DATETIME;
+ALTER TABLE chirps ADD COLUMN updated_at DATETIME;
+ALTER TABLE users ADD COLUMN created_at DATETIME;
+ALTER TABLE users ADD COLUMN updated_at DATETIME;
+
+UPDATE chirps SET created_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP;
+UPDATE users SET created_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP;
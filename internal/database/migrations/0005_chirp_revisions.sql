@@ -0,0 +1,12 @@
+-- Edited chirps record the time of their latest edit, and keep the
+-- bodies they had before, numbered from 1 per chirp.
+ALTER TABLE chirps ADD COLUMN edited_at DATETIME;
+
+CREATE TABLE chirp_revisions (
+	chirp_id    INTEGER  NOT NULL,
+	revision    INTEGER  NOT NULL,
+	body        TEXT     NOT NULL,
+	created_at  DATETIME NOT NULL,
+	replaced_at DATETIME NOT NULL,
+	PRIMARY KEY (chirp_id, revision)
+);
@@ -0,0 +1,5 @@
+-- A reply references the chirp it replies to; NULL for chirps that are
+-- not replies. There is no foreign key, as replies outlive a purged parent.
+ALTER TABLE chirps ADD COLUMN in_reply_to INTEGER;
+
+CREATE INDEX chirps_in_reply_to ON chirps (in_reply_to);
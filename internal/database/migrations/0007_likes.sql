@@ -0,0 +1,9 @@
+-- A user likes a chirp at most once.
+CREATE TABLE likes (
+	chirp_id   INTEGER  NOT NULL,
+	user_id    INTEGER  NOT NULL,
+	created_at DATETIME NOT NULL,
+	PRIMARY KEY (chirp_id, user_id)
+);
+
+CREATE INDEX likes_user_id ON likes (user_id);
@@ -0,0 +1,7 @@
+-- A rechirp references the chirp it shares and a quote chirp the chirp it
+-- quotes; both are NULL otherwise. Like in_reply_to, neither has a foreign
+-- key, as the references outlive a purged chirp.
+ALTER TABLE chirps ADD COLUMN rechirp_of INTEGER;
+ALTER TABLE chirps ADD COLUMN quoted_chirp_id INTEGER;
+
+CREATE INDEX chirps_rechirp_of ON chirps (rechirp_of);
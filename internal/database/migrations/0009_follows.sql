@@ -0,0 +1,9 @@
+-- A user follows another at most once.
+CREATE TABLE follows (
+	follower_id INTEGER  NOT NULL,
+	followee_id INTEGER  NOT NULL,
+	created_at  DATETIME NOT NULL,
+	PRIMARY KEY (follower_id, followee_id)
+);
+
+CREATE INDEX follows_followee_id ON follows (followee_id);
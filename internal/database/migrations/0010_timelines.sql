@@ -0,0 +1,18 @@
+-- Materialized home timelines: the chirps pushed into each user's
+-- timeline. See timelines.go for what goes in.
+CREATE TABLE timeline_entries (
+	user_id  INTEGER NOT NULL,
+	chirp_id INTEGER NOT NULL,
+	PRIMARY KEY (user_id, chirp_id)
+) WITHOUT ROWID;
+
+CREATE INDEX timeline_entries_chirp_id ON timeline_entries (chirp_id);
+
+-- Materialize the existing timelines at the default fanout threshold of
+-- 1000 followers.
+INSERT INTO timeline_entries (user_id, chirp_id)
+SELECT author_id, id FROM chirps WHERE deleted_at IS NULL
+UNION
+SELECT follows.follower_id, chirps.id FROM follows JOIN chirps ON chirps.author_id = follows.followee_id
+WHERE chirps.deleted_at IS NULL
+AND (SELECT COUNT(*) FROM follows AS others WHERE others.followee_id = follows.followee_id) < 1000;
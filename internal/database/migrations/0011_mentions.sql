@@ -0,0 +1,15 @@
+-- The users a chirp's body mentions, as a JSON array of mentions, or NULL
+-- for none. Like in_reply_to, a mention keeps its user ID after the user
+-- is purged.
+ALTER TABLE chirps ADD COLUMN mentions TEXT;
+
+-- A chirp notifies each user it mentions at most once.
+CREATE TABLE notifications (
+	user_id    INTEGER  NOT NULL,
+	chirp_id   INTEGER  NOT NULL,
+	created_at DATETIME NOT NULL,
+	read_at    DATETIME,
+	PRIMARY KEY (user_id, chirp_id)
+);
+
+CREATE INDEX notifications_chirp_id ON notifications (chirp_id);
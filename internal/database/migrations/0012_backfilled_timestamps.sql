@@ -0,0 +1,9 @@
+-- 0004_timestamps gave existing chirps and users the time of the migration,
+-- which made them sort as newly posted and fall inside the edit window.
+-- Their real creation time is unknown; like the JSON store, give them the
+-- Unix epoch instead. CURRENT_TIMESTAMP is the only writer of the bare
+-- 'YYYY-MM-DD HH:MM:SS' form: times the program stores carry a zone.
+UPDATE chirps SET created_at = '1970-01-01 00:00:00+00:00' WHERE length(created_at) = 19;
+UPDATE chirps SET updated_at = '1970-01-01 00:00:00+00:00' WHERE length(updated_at) = 19;
+UPDATE users SET created_at = '1970-01-01 00:00:00+00:00' WHERE length(created_at) = 19;
+UPDATE users SET updated_at = '1970-01-01 00:00:00+00:00' WHERE length(updated_at) = 19;
@@ -0,0 +1,165 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrInvalidQuery is returned for a ChirpQuery whose values contradict
+// each other or are out of range.
+var ErrInvalidQuery = errors.New("invalid chirp query")
+
+// ChirpQuery selects a page of chirps, ordered by ID. Chirps in the trash
+// are never included. The zero value selects every chirp in ascending
+// order.
+type ChirpQuery struct {
+	// AuthorIDs limits the chirps to these authors'; empty includes every
+	// author.
+	AuthorIDs []int
+	// SinceID and MaxID limit the chirps to IDs above SinceID and up to
+	// and including MaxID; 0 leaves that end open.
+	SinceID int
+	MaxID   int
+	// Since and Until limit the chirps to those created at or after Since
+	// and before Until; the zero time leaves that end open.
+	Since time.Time
+	Until time.Time
+	// Contains limits the chirps to those whose body contains it,
+	// matching case.
+	Contains string
+
+	// Descending orders the chirps newest first.
+	Descending bool
+	// AfterID continues after the chirp with this ID in the chosen order,
+	// as returned last on the previous page; 0 starts at the beginning.
+	// Chirps added in the meantime don't shift the pages, since IDs only
+	// grow.
+	AfterID int
+	// Limit is the maximum number of chirps to return; 0 returns them all.
+	Limit int
+}
+
+// ChirpPage is the result of a ChirpQuery.
+type ChirpPage struct {
+	Chirps []Chirp
+	// More reports whether more chirps follow the last one returned.
+	More bool
+}
+
+// Validate returns an error wrapping ErrInvalidQuery if the query can't
+// match anything because its values are out of range or contradict each
+// other.
+func (query ChirpQuery) Validate() error {
+	for _, id := range query.AuthorIDs {
+		if id < 1 {
+			return fmt.Errorf("%w: author ID %d", ErrInvalidQuery, id)
+		}
+	}
+	if query.SinceID < 0 || query.MaxID < 0 || query.AfterID < 0 {
+		return fmt.Errorf("%w: chirp IDs can't be negative", ErrInvalidQuery)
+	}
+	if query.MaxID > 0 && query.MaxID <= query.SinceID {
+		return fmt.Errorf("%w: max ID %d is not above since ID %d", ErrInvalidQuery, query.MaxID, query.SinceID)
+	}
+	if !query.Since.IsZero() && !query.Until.IsZero() && !query.Until.After(query.Since) {
+		return fmt.Errorf("%w: until is not after since", ErrInvalidQuery)
+	}
+	if query.Limit < 0 {
+		return fmt.Errorf("%w: negative limit", ErrInvalidQuery)
+	}
+	return nil
+}
+
+// idBounds returns the range of IDs, lowest excluded and highest included,
+// that the query's ID filters and position leave to consider. Without an
+// upper bound highest is math.MaxInt; a highest of 0 leaves nothing, as
+// after the last page of a descending listing.
+func (query ChirpQuery) idBounds() (lowest, highest int) {
+	lowest, highest = query.SinceID, math.MaxInt
+	if query.MaxID > 0 {
+		highest = query.MaxID
+	}
+	if query.AfterID > 0 {
+		if query.Descending {
+			highest = min(highest, query.AfterID-1)
+		} else {
+			lowest = max(lowest, query.AfterID)
+		}
+	}
+	return lowest, highest
+}
+
+// matches reports whether chirp passes the query's filters other than
+// the author and ID ones.
+func (query ChirpQuery) matches(chirp Chirp) bool {
+	if !query.Since.IsZero() && chirp.CreatedAt.Before(query.Since) {
+		return false
+	}
+	if !query.Until.IsZero() && !chirp.CreatedAt.Before(query.Until) {
+		return false
+	}
+	return strings.Contains(chirp.Body, query.Contains)
+}
+
+// ListChirps returns a page of chirps, served from the sorted ID indexes:
+// the ID filters and position narrow them down by binary search, and the
+// remaining filters are checked chirp by chirp until the page is full.
+func (db *DB) ListChirps(query ChirpQuery) (ChirpPage, error) {
+	err := query.Validate()
+	if err != nil {
+		return ChirpPage{}, err
+	}
+
+	page := ChirpPage{}
+	err = db.View(func(dbStructure DBStructure) error {
+		page = db.listChirps(dbStructure, query)
+		return nil
+	})
+	if err != nil {
+		return ChirpPage{}, err
+	}
+
+	return page, nil
+}
+
+// listChirps runs a valid query. db.mu must be held.
+func (db *DB) listChirps(dbStructure DBStructure, query ChirpQuery) ChirpPage {
+	page := ChirpPage{
+		Chirps: []Chirp{},
+	}
+	ids := db.idx.chirpIDs
+	if len(query.AuthorIDs) > 0 {
+		ids = db.idx.chirpsByAuthors(query.AuthorIDs)
+	}
+
+	lowest, highest := query.idBounds()
+	start := sort.SearchInts(ids, lowest+1)
+	end := sort.Search(len(ids), func(i int) bool {
+		return ids[i] > highest
+	})
+	if start >= end {
+		return page
+	}
+	ids = ids[start:end]
+
+	for i := range ids {
+		id := ids[i]
+		if query.Descending {
+			id = ids[len(ids)-1-i]
+		}
+		chirp := dbStructure.Chirps[id]
+		if !query.matches(chirp) {
+			continue
+		}
+		if query.Limit > 0 && len(page.Chirps) == query.Limit {
+			page.More = true
+			break
+		}
+		page.Chirps = append(page.Chirps, chirp)
+	}
+	return page
+}
@@ -0,0 +1,50 @@
+package database
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// TestListChirpsDescendingLastPage checks that continuing a descending
+// listing after its lowest chirp returns an empty page, with or without an
+// upper ID bound.
+func TestListChirpsDescendingLastPage(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	defer db.Close()
+
+	user, err := db.CreateUser("author@example.com", "hash")
+	if err != nil {
+		t.Fatalf("creating user: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		_, err := db.CreateChirp(NewChirp{Body: fmt.Sprintf("chirp %d", i), AuthorID: user.ID})
+		if err != nil {
+			t.Fatalf("creating chirp: %v", err)
+		}
+	}
+
+	for _, query := range []ChirpQuery{
+		{Descending: true, AfterID: 1},
+		{Descending: true, AfterID: 1, MaxID: 3},
+	} {
+		page, err := db.ListChirps(query)
+		if err != nil {
+			t.Fatalf("listing %+v: %v", query, err)
+		}
+		if len(page.Chirps) != 0 || page.More {
+			t.Errorf("listing %+v: got %d chirps, more %v, want none", query, len(page.Chirps), page.More)
+		}
+	}
+
+	page, err := db.ListChirps(ChirpQuery{Descending: true, AfterID: 3})
+	if err != nil {
+		t.Fatalf("listing after chirp 3: %v", err)
+	}
+	if len(page.Chirps) != 2 || page.Chirps[0].ID != 2 || page.Chirps[1].ID != 1 {
+		t.Errorf("listing after chirp 3: got %+v, want chirps 2 and 1", page.Chirps)
+	}
+}
@@ -0,0 +1,68 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrInvalidQuote is returned when a new chirp quotes a chirp that doesn't
+// exist or is in the trash.
+var ErrInvalidQuote = errors.New("quoted chirp does not exist")
+
+// ErrRechirpEdit is returned when editing a rechirp, which has no body of
+// its own to edit.
+var ErrRechirpEdit = errors.New("rechirps can't be edited")
+
+// visibleOriginal returns the chirp with the ID, or the chirp it shares if
+// it is a rechirp, provided that chirp is not in the trash.
+func visibleOriginal(chirps map[int]Chirp, id int) (Chirp, bool) {
+	chirp, ok := chirps[id]
+	if ok && chirp.RechirpOf != 0 {
+		chirp, ok = chirps[chirp.RechirpOf]
+	}
+	if !ok || chirp.DeletedAt != nil {
+		return Chirp{}, false
+	}
+	return chirp, true
+}
+
+// RechirpChirp shares a chirp as the user by posting a rechirp of it.
+// Rechirping a rechirp shares the chirp it shares, and rechirping a chirp
+// again returns the user's earlier rechirp. It returns ErrUnauthorized if
+// the user doesn't exist or is in the trash, and ErrNotExist if the chirp
+// doesn't exist or is in the trash.
+func (db *DB) RechirpChirp(chirpID, userID int) (Chirp, error) {
+	rechirp := Chirp{}
+	err := db.update(func(t *tx) error {
+		err := t.activeUser(userID)
+		if err != nil {
+			return err
+		}
+		original, ok := visibleOriginal(t.data.Chirps, chirpID)
+		if !ok {
+			return ErrNotExist
+		}
+		for _, id := range t.idx.chirpsByAuthor[userID] {
+			if t.data.Chirps[id].RechirpOf == original.ID {
+				rechirp = t.data.Chirps[id]
+				return nil
+			}
+		}
+
+		now := time.Now().UTC()
+		rechirp = Chirp{
+			ID:        t.data.nextChirpID(),
+			AuthorID:  userID,
+			RechirpOf: original.ID,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		t.putChirp(rechirp)
+		t.pushChirp(rechirp, db.fanoutThreshold)
+		return nil
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+	return rechirp, nil
+}
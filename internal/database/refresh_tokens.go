@@ -11,57 +11,44 @@ type RefreshToken struct {
 }
 
 func (db *DB) SaveRefreshToken(userID int, token string) error {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return err
-	}
-
-	refreshToken := RefreshToken{
-		UserID:    userID,
-		Token:     token,
-		ExpiresAt: time.Now().Add(time.Hour),
-	}
-	dbStructure.RefreshTokens[token] = refreshToken
-
-	err = db.writeDB(dbStructure)
-	if err != nil {
-		return err
-	}
-	return nil
+	return db.update(func(t *tx) error {
+		t.putRefreshToken(RefreshToken{
+			UserID:    userID,
+			Token:     token,
+			ExpiresAt: time.Now().Add(time.Hour),
+		})
+		return nil
+	})
 }
 
 func (db *DB) RevokeRefreshToken(token string) error {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return err
-	}
-	delete(dbStructure.RefreshTokens, token)
-	err = db.writeDB(dbStructure)
-	if err != nil {
-		return err
-	}
-	return nil
+	return db.update(func(t *tx) error {
+		t.deleteRefreshToken(token)
+		return nil
+	})
 }
 
 func (db *DB) UserForRefreshToken(token string) (User, error) {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return User{}, err
-	}
-
-	refreshToken, ok := dbStructure.RefreshTokens[token]
-	if !ok {
-		return User{}, ErrNotExist
-	}
-
-	if refreshToken.ExpiresAt.Before(time.Now()) {
-		return User{}, ErrNotExist
-	}
-
-	user, err := db.GetUser(refreshToken.UserID)
+	user := User{}
+	err := db.View(func(dbStructure DBStructure) error {
+		refreshToken, ok := dbStructure.RefreshTokens[token]
+		if !ok {
+			return ErrNotExist
+		}
+
+		if refreshToken.ExpiresAt.Before(time.Now()) {
+			return ErrNotExist
+		}
+
+		user, ok = dbStructure.Users[refreshToken.UserID]
+		if !ok || user.DeletedAt != nil {
+			return ErrNotExist
+		}
+		return nil
+	})
 	if err != nil {
 		return User{}, err
 	}
 
 	return user, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"time"
+)
+
+// ChirpRevision is a body a chirp had before it was edited.
+type ChirpRevision struct {
+	// Revision numbers a chirp's revisions from 1, oldest first.
+	Revision int    `json:"revision"`
+	Body     string `json:"body"`
+	// CreatedAt is when the chirp got this body, by being posted or
+	// edited, and ReplacedAt when an edit replaced it. Both are in UTC.
+	CreatedAt  time.Time `json:"created_at"`
+	ReplacedAt time.Time `json:"replaced_at"`
+}
+
+// EditChirp replaces the body and mentions of a chirp, keeping the old
+// body as a revision, and notifies the users the new body mentions for the
+// first time. Editing a chirp to the body it already has changes nothing.
+// It returns ErrNotExist if the chirp doesn't exist or is in the trash,
+// ErrUnauthorized if its author is in the trash, and ErrRechirpEdit if it
+// is a rechirp.
+func (db *DB) EditChirp(id int, body string, mentions []Mention) (Chirp, error) {
+	chirp := Chirp{}
+	err := db.update(func(t *tx) error {
+		var ok bool
+		chirp, ok = t.data.Chirps[id]
+		if !ok || chirp.DeletedAt != nil {
+			return ErrNotExist
+		}
+		err := t.activeUser(chirp.AuthorID)
+		if err != nil {
+			return err
+		}
+		if chirp.RechirpOf != 0 {
+			return ErrRechirpEdit
+		}
+		if chirp.Body == body {
+			return nil
+		}
+
+		now := time.Now().UTC()
+		revisions := t.data.ChirpRevisions[id]
+		t.putChirpRevisions(id, append(revisions[:len(revisions):len(revisions)], ChirpRevision{
+			Revision:   len(revisions) + 1,
+			Body:       chirp.Body,
+			CreatedAt:  chirp.bodyCreatedAt(),
+			ReplacedAt: now,
+		}))
+
+		before := chirp
+		chirp.Body = body
+		chirp.Mentions = mentions
+		chirp.EditedAt = &now
+		chirp.UpdatedAt = now
+		t.putChirp(chirp)
+		t.notifyMentions(chirp, before)
+		return nil
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+// bodyCreatedAt returns when the chirp got its current body.
+func (chirp Chirp) bodyCreatedAt() time.Time {
+	if chirp.EditedAt != nil {
+		return *chirp.EditedAt
+	}
+	return chirp.CreatedAt
+}
+
+// GetChirpRevisions returns the bodies the chirp had before its edits,
+// oldest first. It returns ErrNotExist if the chirp doesn't exist or is in
+// the trash.
+func (db *DB) GetChirpRevisions(id int) ([]ChirpRevision, error) {
+	revisions := []ChirpRevision{}
+	err := db.View(func(dbStructure DBStructure) error {
+		chirp, ok := dbStructure.Chirps[id]
+		if !ok || chirp.DeletedAt != nil {
+			return ErrNotExist
+		}
+		revisions = append(revisions, dbStructure.ChirpRevisions[id]...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// RotateKey re-encrypts the database with newCipher: the current state is
+// written as a new snapshot, which also empties the WAL, and every kept
+// generation is rewritten in place. A nil newCipher turns encryption off.
+func (db *DB) RotateKey(newCipher *Cipher) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.flushMu.Lock()
+	defer db.flushMu.Unlock()
+
+	if db.readOnly {
+		return ErrReadOnly
+	}
+
+	oldCipher := db.cipher
+	db.cipher = newCipher
+	err := db.writeSnapshotLocked()
+	if err != nil {
+		db.cipher = oldCipher
+		return err
+	}
+
+	err = db.dataLock.lock(true, db.lockTimeout)
+	if err != nil {
+		return err
+	}
+	defer db.dataLock.unlock()
+	for n := 1; n <= db.generations; n++ {
+		err := reencryptFile(generationPath(db.path, n), oldCipher, newCipher)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// RotateKey re-encrypts every backup in place with newCipher and returns
+// how many were rewritten. b.Cipher must be the key they were written
+// with; plaintext backups are encrypted as well.
+func (b Backups) RotateKey(newCipher *Cipher) (int, error) {
+	entries, err := os.ReadDir(b.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	rotated := 0
+	for _, entry := range entries {
+		if entry.IsDir() || !isBackupName(entry.Name()) {
+			continue
+		}
+		err := reencryptFile(filepath.Join(b.Dir, entry.Name()), b.Cipher, newCipher)
+		if err != nil {
+			return rotated, err
+		}
+		rotated++
+	}
+	return rotated, nil
+}
+
+func reencryptFile(path string, oldCipher, newCipher *Cipher) error {
+	dat, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	plaintext, err := decodeFile(oldCipher, dat)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return writeFileAtomic(path, encodeFile(newCipher, plaintext))
+}
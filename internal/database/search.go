@@ -0,0 +1,482 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ErrInvalidSearch is returned for a search query that can't be parsed or
+// matches nothing by construction, such as one made only of NOT terms.
+var ErrInvalidSearch = errors.New("invalid search query")
+
+const (
+	// BM25 parameters: how quickly repeating a term stops adding to a
+	// chirp's relevance, and how much longer chirps are penalized.
+	bm25K1 = 1.2
+	bm25B  = 0.75
+	// searchRecencyHalfLife is the age at which a chirp's recency boost
+	// has halved. A new chirp scores up to twice an old one of the same
+	// relevance.
+	searchRecencyHalfLife = 7 * 24 * time.Hour
+)
+
+// SearchQuery selects chirps by a full-text query over their bodies.
+//
+// Words are lowercased and stemmed, so "Posting" matches "posts". Words
+// are ANDed together; OR between two terms matches either, and NOT or a
+// leading - excludes chirps containing the term that follows. A "quoted
+// phrase" matches its words in that order. Parentheses group terms, and OR
+// binds looser than AND: "a b OR c" is "(a b) OR c".
+type SearchQuery struct {
+	Query string
+	// Offset skips that many of the best results.
+	Offset int
+	// Limit is the maximum number of chirps to return; 0 returns them all.
+	Limit int
+}
+
+// SearchResult is a page of the chirps a SearchQuery matched, best first.
+type SearchResult struct {
+	Chirps []Chirp
+	// Total is how many chirps matched, across all pages.
+	Total int
+}
+
+// tokenize splits text into lowercased, stemmed terms at anything that
+// isn't a letter or digit, in the order they appear.
+func tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, word := range words {
+		words[i] = stem(word)
+	}
+	return words
+}
+
+// searchIndex is an inverted index over the bodies of the chirps that are
+// not in the trash. It is not safe for concurrent use; each store guards
+// it with the lock that guards its other indexes.
+type searchIndex struct {
+	// postings maps a term to the chirps containing it and the positions
+	// it occurs at in each.
+	postings map[string]map[int][]int
+	docs     map[int]searchDoc
+	// totalLength is the sum of the docs' lengths.
+	totalLength int
+}
+
+// searchDoc is what the index keeps about a chirp to rank and remove it.
+type searchDoc struct {
+	// terms are the chirp's distinct terms.
+	terms     []string
+	length    int
+	createdAt time.Time
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		postings: map[string]map[int][]int{},
+		docs:     map[int]searchDoc{},
+	}
+}
+
+func (s *searchIndex) add(chirp Chirp) {
+	// Rechirps have no text of their own to find.
+	if chirp.DeletedAt != nil || chirp.RechirpOf != 0 {
+		return
+	}
+	s.remove(chirp.ID)
+
+	terms := tokenize(chirp.Body)
+	doc := searchDoc{
+		length:    len(terms),
+		createdAt: chirp.CreatedAt,
+	}
+	for pos, term := range terms {
+		docs, ok := s.postings[term]
+		if !ok {
+			docs = map[int][]int{}
+			s.postings[term] = docs
+		}
+		if _, ok := docs[chirp.ID]; !ok {
+			doc.terms = append(doc.terms, term)
+		}
+		docs[chirp.ID] = append(docs[chirp.ID], pos)
+	}
+	s.docs[chirp.ID] = doc
+	s.totalLength += doc.length
+}
+
+func (s *searchIndex) remove(id int) {
+	doc, ok := s.docs[id]
+	if !ok {
+		return
+	}
+	for _, term := range doc.terms {
+		delete(s.postings[term], id)
+		if len(s.postings[term]) == 0 {
+			delete(s.postings, term)
+		}
+	}
+	delete(s.docs, id)
+	s.totalLength -= doc.length
+}
+
+// search returns the IDs of the chirps matching query, best first.
+func (s *searchIndex) search(query searchNode, now time.Time) []int {
+	matches := query.eval(s)
+	terms := query.scoringTerms()
+
+	scores := make(map[int]float64, len(matches))
+	avgLength := float64(s.totalLength) / float64(max(len(s.docs), 1))
+	for id := range matches {
+		doc := s.docs[id]
+		relevance := 0.0
+		for _, term := range terms {
+			tf := float64(len(s.postings[term][id]))
+			if tf == 0 {
+				continue
+			}
+			df := float64(len(s.postings[term]))
+			idf := math.Log(1 + (float64(len(s.docs))-df+0.5)/(df+0.5))
+			relevance += idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*float64(doc.length)/avgLength))
+		}
+		age := max(now.Sub(doc.createdAt), 0)
+		recency := math.Exp2(-float64(age) / float64(searchRecencyHalfLife))
+		scores[id] = relevance * (1 + recency)
+	}
+
+	ids := make([]int, 0, len(matches))
+	for id := range matches {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if scores[ids[i]] != scores[ids[j]] {
+			return scores[ids[i]] > scores[ids[j]]
+		}
+		return ids[i] > ids[j]
+	})
+	return ids
+}
+
+// searchNode is a parsed search query.
+type searchNode interface {
+	// eval returns the IDs of the chirps that match.
+	eval(s *searchIndex) map[int]bool
+	// scoringTerms returns the terms that make a chirp more relevant:
+	// those it is searched for, not those it is excluded for.
+	scoringTerms() []string
+}
+
+// phraseNode matches chirps containing its terms in order. A single word
+// is a phrase of one term.
+type phraseNode struct {
+	terms []string
+}
+
+func (n phraseNode) eval(s *searchIndex) map[int]bool {
+	matches := map[int]bool{}
+	first := s.postings[n.terms[0]]
+	for id, positions := range first {
+		for _, pos := range positions {
+			if s.phraseAt(n.terms[1:], id, pos+1) {
+				matches[id] = true
+				break
+			}
+		}
+	}
+	return matches
+}
+
+// phraseAt reports whether chirp id has terms in order from position pos.
+func (s *searchIndex) phraseAt(terms []string, id, pos int) bool {
+	for i, term := range terms {
+		positions := s.postings[term][id]
+		j := sort.SearchInts(positions, pos+i)
+		if j == len(positions) || positions[j] != pos+i {
+			return false
+		}
+	}
+	return true
+}
+
+func (n phraseNode) scoringTerms() []string {
+	return n.terms
+}
+
+// andNode matches chirps matching all of include and none of exclude.
+type andNode struct {
+	include []searchNode
+	exclude []searchNode
+}
+
+func (n andNode) eval(s *searchIndex) map[int]bool {
+	matches := n.include[0].eval(s)
+	for _, child := range n.include[1:] {
+		other := child.eval(s)
+		for id := range matches {
+			if !other[id] {
+				delete(matches, id)
+			}
+		}
+	}
+	for _, child := range n.exclude {
+		for id := range child.eval(s) {
+			delete(matches, id)
+		}
+	}
+	return matches
+}
+
+func (n andNode) scoringTerms() []string {
+	terms := []string{}
+	for _, child := range n.include {
+		terms = append(terms, child.scoringTerms()...)
+	}
+	return terms
+}
+
+// orNode matches chirps matching any of its children.
+type orNode struct {
+	children []searchNode
+}
+
+func (n orNode) eval(s *searchIndex) map[int]bool {
+	matches := map[int]bool{}
+	for _, child := range n.children {
+		for id := range child.eval(s) {
+			matches[id] = true
+		}
+	}
+	return matches
+}
+
+func (n orNode) scoringTerms() []string {
+	terms := []string{}
+	for _, child := range n.children {
+		terms = append(terms, child.scoringTerms()...)
+	}
+	return terms
+}
+
+// parseSearch parses a query in the syntax described on SearchQuery.
+func parseSearch(query string) (searchNode, error) {
+	p := searchParser{tokens: lexSearch(query)}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("%w: unexpected %q", ErrInvalidSearch, p.tokens[p.pos])
+	}
+	return node, nil
+}
+
+// lexSearch splits a query into parentheses, quoted phrases, kept with
+// their opening quote, and words. A phrase missing its closing quote runs
+// to the end of the query.
+func lexSearch(query string) []string {
+	tokens := []string{}
+	for i := 0; i < len(query); {
+		switch c := query[i]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, query[i:i+1])
+			i++
+		case c == '"':
+			end := strings.IndexByte(query[i+1:], '"')
+			if end < 0 {
+				tokens = append(tokens, query[i:])
+				i = len(query)
+			} else {
+				tokens = append(tokens, query[i:i+1+end])
+				i += end + 2
+			}
+		default:
+			end := strings.IndexAny(query[i:], " \t\r\n()\"")
+			if end < 0 {
+				end = len(query) - i
+			}
+			tokens = append(tokens, query[i:i+end])
+			i += end
+		}
+	}
+	return tokens
+}
+
+type searchParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *searchParser) peek() string {
+	if p.pos == len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+// parseOr parses: and ("OR" and)*
+func (p *searchParser) parseOr() (searchNode, error) {
+	children := []searchNode{}
+	for {
+		child, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+		if p.peek() != "OR" {
+			break
+		}
+		p.pos++
+	}
+	if len(children) == 1 {
+		return children[0], nil
+	}
+	return orNode{children: children}, nil
+}
+
+// parseAnd parses a sequence of possibly negated terms, optionally joined
+// by AND, up to an OR, a closing parenthesis or the end.
+func (p *searchParser) parseAnd() (searchNode, error) {
+	n := andNode{}
+	for {
+		switch p.peek() {
+		case "", "OR", ")":
+			if len(n.include) == 0 {
+				if len(n.exclude) > 0 {
+					return nil, fmt.Errorf("%w: NOT needs a term to match alongside it", ErrInvalidSearch)
+				}
+				return nil, fmt.Errorf("%w: expected a term", ErrInvalidSearch)
+			}
+			if len(n.include) == 1 && len(n.exclude) == 0 {
+				return n.include[0], nil
+			}
+			return n, nil
+		case "AND":
+			p.pos++
+			continue
+		}
+
+		node, negated, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if node == nil {
+			// A word without letters or digits, such as "&".
+			continue
+		}
+		if negated {
+			n.exclude = append(n.exclude, node)
+		} else {
+			n.include = append(n.include, node)
+		}
+	}
+}
+
+// parseUnary parses a term, phrase or parenthesized query, with any NOTs
+// or -s before it. It returns a nil node for a word with no terms.
+func (p *searchParser) parseUnary() (searchNode, bool, error) {
+	negated := false
+	for p.peek() == "NOT" {
+		negated = !negated
+		p.pos++
+	}
+
+	token := p.peek()
+	switch {
+	case token == "", token == "OR", token == ")", token == "AND":
+		return nil, false, fmt.Errorf("%w: expected a term after NOT", ErrInvalidSearch)
+	case token == "(":
+		p.pos++
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, false, err
+		}
+		if p.peek() != ")" {
+			return nil, false, fmt.Errorf("%w: missing )", ErrInvalidSearch)
+		}
+		p.pos++
+		return node, negated, nil
+	case token[0] == '"':
+		p.pos++
+		terms := tokenize(token[1:])
+		if len(terms) == 0 {
+			return nil, negated, nil
+		}
+		return phraseNode{terms: terms}, negated, nil
+	default:
+		p.pos++
+		if strings.HasPrefix(token, "-") {
+			negated = !negated
+		}
+		// A word such as "don't" is a phrase of its parts.
+		terms := tokenize(token)
+		if len(terms) == 0 {
+			return nil, negated, nil
+		}
+		return phraseNode{terms: terms}, negated, nil
+	}
+}
+
+// SearchChirps returns the chirps matching query, ranked by how well they
+// match and by how recent they are.
+func (db *DB) SearchChirps(query SearchQuery) (SearchResult, error) {
+	node, err := parseSearch(query.Query)
+	if err != nil {
+		return SearchResult{}, err
+	}
+
+	result := SearchResult{
+		Chirps: []Chirp{},
+	}
+	err = db.View(func(dbStructure DBStructure) error {
+		ids := db.idx.search.search(node, time.Now())
+		result.Total = len(ids)
+		for _, id := range pageSearchIDs(ids, query) {
+			result.Chirps = append(result.Chirps, dbStructure.Chirps[id])
+		}
+		return nil
+	})
+	if err != nil {
+		return SearchResult{}, err
+	}
+	return result, nil
+}
+
+// pageSearchIDs returns the ranked IDs the query's offset and limit select.
+func pageSearchIDs(ids []int, query SearchQuery) []int {
+	if query.Offset >= len(ids) {
+		return nil
+	}
+	ids = ids[max(query.Offset, 0):]
+	if query.Limit > 0 && len(ids) > query.Limit {
+		ids = ids[:query.Limit]
+	}
+	return ids
+}
+
+// RebuildSearchIndex rebuilds the search index from the chirps and returns
+// how many it indexed.
+func (db *DB) RebuildSearchIndex() (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.idx.search = buildSearchIndex(db.data.Chirps)
+	return len(db.idx.search.docs), nil
+}
+
+func buildSearchIndex(chirps map[int]Chirp) *searchIndex {
+	s := newSearchIndex()
+	for _, chirp := range chirps {
+		s.add(chirp)
+	}
+	return s
+}
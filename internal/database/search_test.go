@@ -0,0 +1,170 @@
+package database
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// searchIndexOf indexes chirps with the given bodies, with IDs from 1 in
+// order, all created at createdAt.
+func searchIndexOf(createdAt time.Time, bodies ...string) *searchIndex {
+	chirps := map[int]Chirp{}
+	for i, body := range bodies {
+		chirps[i+1] = Chirp{ID: i + 1, Body: body, CreatedAt: createdAt}
+	}
+	return buildSearchIndex(chirps)
+}
+
+// TestSearchMatches checks which chirps each part of the query syntax
+// matches, regardless of their ranking.
+func TestSearchMatches(t *testing.T) {
+	now := time.Now()
+	s := searchIndexOf(now,
+		"Posting my first chirp",
+		"the first post was good",
+		"chirp chirp goes the bird",
+		"a bird in the hand",
+		"don't post that",
+	)
+	tests := []struct {
+		query string
+		want  []int
+	}{
+		{"post", []int{1, 2, 5}},
+		{"POSTS", []int{1, 2, 5}},
+		{"first post", []int{1, 2}},
+		{"first AND post", []int{1, 2}},
+		{`"first post"`, []int{2}},
+		{`"post first"`, []int{}},
+		{`"first post`, []int{2}},
+		{"post -first", []int{5}},
+		{"post NOT first", []int{5}},
+		{"post NOT \"first post\"", []int{1, 5}},
+		{"NOT NOT bird", []int{3, 4}},
+		{"bird OR first", []int{1, 2, 3, 4}},
+		{"chirp bird OR hand", []int{3, 4}},
+		{"chirp (bird OR hand)", []int{3}},
+		{"bird NOT (chirp OR goes)", []int{4}},
+		{"don't", []int{5}},
+		{"bird & hand", []int{4}},
+		{"missing", []int{}},
+	}
+	for _, tt := range tests {
+		node, err := parseSearch(tt.query)
+		if err != nil {
+			t.Errorf("parsing %q: %v", tt.query, err)
+			continue
+		}
+		got := s.search(node, now)
+		sort.Ints(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("searching %q: got %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+// TestSearchParseErrors checks that queries that can't be parsed, or that
+// have nothing to match, are refused with ErrInvalidSearch.
+func TestSearchParseErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{"empty", ""},
+		{"only spaces", "   "},
+		{"only punctuation", "& !"},
+		{"only an empty phrase", `""`},
+		{"only NOT", "NOT bird"},
+		{"only -", "-bird"},
+		{"only NOTs", "-bird NOT hand"},
+		{"NOT without a term", "bird NOT"},
+		{"NOT before OR", "bird NOT OR hand"},
+		{"OR without a left side", "OR bird"},
+		{"OR without a right side", "bird OR"},
+		{"OR with a NOT side", "bird OR -hand"},
+		{"unclosed parenthesis", "(bird hand"},
+		{"unopened parenthesis", "bird hand)"},
+		{"empty parentheses", "bird ()"},
+	}
+	for _, tt := range tests {
+		_, err := parseSearch(tt.query)
+		if !errors.Is(err, ErrInvalidSearch) {
+			t.Errorf("%s: parsing %q: got %v, want ErrInvalidSearch", tt.name, tt.query, err)
+		}
+	}
+}
+
+// TestSearchRanking checks the order of the results: BM25 relevance, then
+// a boost for recent chirps, then the newest ID first on a tie.
+func TestSearchRanking(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-365 * 24 * time.Hour)
+	tests := []struct {
+		name   string
+		query  string
+		bodies []string
+		// created is when each chirp was posted; now if unset.
+		created map[int]time.Time
+		want    []int
+	}{
+		{
+			name:   "more occurrences first",
+			query:  "coffee",
+			bodies: []string{"coffee is good", "coffee coffee coffee is good"},
+			want:   []int{2, 1},
+		},
+		{
+			name:   "shorter chirps first",
+			query:  "coffee",
+			bodies: []string{"coffee with milk and sugar and cream", "coffee black"},
+			want:   []int{2, 1},
+		},
+		{
+			name:   "rarer terms first",
+			query:  "coffee OR tea",
+			bodies: []string{"tea", "coffee", "coffee"},
+			want:   []int{1, 3, 2},
+		},
+		{
+			name:    "newer first at the same relevance",
+			query:   "coffee",
+			bodies:  []string{"coffee", "coffee"},
+			created: map[int]time.Time{2: old},
+			want:    []int{1, 2},
+		},
+		{
+			name:  "recency at most doubles relevance",
+			query: "coffee",
+			bodies: []string{
+				"coffee coffee coffee coffee",
+				"coffee and one two three four five six seven eight nine",
+			},
+			created: map[int]time.Time{1: old},
+			want:    []int{1, 2},
+		},
+		{
+			name:   "higher ID first on a tie",
+			query:  "coffee",
+			bodies: []string{"coffee", "coffee", "coffee"},
+			want:   []int{3, 2, 1},
+		},
+	}
+	for _, tt := range tests {
+		s := searchIndexOf(now, tt.bodies...)
+		for id, createdAt := range tt.created {
+			chirp := Chirp{ID: id, Body: tt.bodies[id-1], CreatedAt: createdAt}
+			s.add(chirp)
+		}
+		node, err := parseSearch(tt.query)
+		if err != nil {
+			t.Fatalf("%s: parsing %q: %v", tt.name, tt.query, err)
+		}
+		got := s.search(node, now)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: searching %q: got %v, want %v", tt.name, tt.query, got, tt.want)
+		}
+	}
+}
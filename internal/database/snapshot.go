@@ -0,0 +1,196 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// ErrInvalidSnapshot is returned when a snapshot's contents are not
+// consistent enough to be swapped in.
+var ErrInvalidSnapshot = errors.New("invalid snapshot")
+
+// Snapshot returns a deep copy of the database taken under the read lock.
+func (db *DB) Snapshot() (DBStructure, error) {
+	db.mu.RLock()
+	dat, err := json.Marshal(db.data)
+	db.mu.RUnlock()
+	if err != nil {
+		return DBStructure{}, err
+	}
+
+	return decodeStructure(dat)
+}
+
+// decodeStructure decodes a structure encoded with json.Marshal, the way
+// copies of the in-memory one are taken.
+func decodeStructure(dat []byte) (DBStructure, error) {
+	dbStructure := DBStructure{}
+	err := json.Unmarshal(dat, &dbStructure)
+	if err != nil {
+		return DBStructure{}, err
+	}
+	dbStructure.initMaps()
+	return dbStructure, nil
+}
+
+// Restore validates dbStructure and swaps it in for the whole database.
+// The new state is written as a snapshot before Restore returns.
+func (db *DB) Restore(dbStructure DBStructure) error {
+	err := dbStructure.Validate()
+	if err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+	if db.readOnly {
+		return ErrReadOnly
+	}
+
+	db.data = dbStructure
+	db.idx = buildIndexes(&db.data)
+	db.feed.publish(Event{Kind: EventReset})
+	return db.compactLocked()
+}
+
+// Validate checks that the structure is at the current schema version,
+// that every record is stored under its own key, that emails are unique
+// and that the ID sequences are past every ID in use.
+func (dbStructure DBStructure) Validate() error {
+	if dbStructure.SchemaVersion != currentSchemaVersion {
+		return fmt.Errorf("%w: schema version %d, want %d", ErrInvalidSnapshot, dbStructure.SchemaVersion, currentSchemaVersion)
+	}
+	if dbStructure.Chirps == nil || dbStructure.Users == nil || dbStructure.RefreshTokens == nil || dbStructure.ChirpRevisions == nil || dbStructure.Likes == nil || dbStructure.Follows == nil || dbStructure.Timelines == nil || dbStructure.Notifications == nil {
+		return fmt.Errorf("%w: missing tables", ErrInvalidSnapshot)
+	}
+
+	emails := map[string]int{}
+	for id, user := range dbStructure.Users {
+		if user.ID != id {
+			return fmt.Errorf("%w: user %d stored under ID %d", ErrInvalidSnapshot, user.ID, id)
+		}
+		if user.Email == "" {
+			return fmt.Errorf("%w: user %d has no email", ErrInvalidSnapshot, id)
+		}
+		key := normalizeEmail(user.Email)
+		if other, ok := emails[key]; ok {
+			return fmt.Errorf("%w: users %d and %d share an email", ErrInvalidSnapshot, other, id)
+		}
+		emails[key] = id
+		if id > dbStructure.Sequences.Users {
+			return fmt.Errorf("%w: user %d is past the user sequence", ErrInvalidSnapshot, id)
+		}
+	}
+
+	for id, chirp := range dbStructure.Chirps {
+		if chirp.ID != id {
+			return fmt.Errorf("%w: chirp %d stored under ID %d", ErrInvalidSnapshot, chirp.ID, id)
+		}
+		if id > dbStructure.Sequences.Chirps {
+			return fmt.Errorf("%w: chirp %d is past the chirp sequence", ErrInvalidSnapshot, id)
+		}
+		// A reply is posted after its parent, so it has a higher ID. The
+		// parent may have been purged since.
+		if chirp.InReplyTo < 0 || chirp.InReplyTo >= id {
+			return fmt.Errorf("%w: chirp %d replies to chirp %d", ErrInvalidSnapshot, id, chirp.InReplyTo)
+		}
+		// The same goes for the chirps rechirped and quoted. A rechirp is
+		// only a reference, with no body, reply or quote of its own.
+		if chirp.RechirpOf < 0 || chirp.RechirpOf >= id {
+			return fmt.Errorf("%w: chirp %d rechirps chirp %d", ErrInvalidSnapshot, id, chirp.RechirpOf)
+		}
+		if chirp.QuotedChirpID < 0 || chirp.QuotedChirpID >= id {
+			return fmt.Errorf("%w: chirp %d quotes chirp %d", ErrInvalidSnapshot, id, chirp.QuotedChirpID)
+		}
+		if chirp.RechirpOf != 0 && (chirp.Body != "" || chirp.InReplyTo != 0 || chirp.QuotedChirpID != 0) {
+			return fmt.Errorf("%w: rechirp %d has content of its own", ErrInvalidSnapshot, id)
+		}
+		// Mentions may name users purged since, but must lie within the
+		// body, in order.
+		length := utf8.RuneCountInString(chirp.Body)
+		end := 0
+		for _, mention := range chirp.Mentions {
+			if mention.Start < end || mention.End <= mention.Start || mention.End > length {
+				return fmt.Errorf("%w: chirp %d has a mention outside its body", ErrInvalidSnapshot, id)
+			}
+			end = mention.End
+		}
+	}
+
+	for chirpID, revisions := range dbStructure.ChirpRevisions {
+		if _, ok := dbStructure.Chirps[chirpID]; !ok {
+			return fmt.Errorf("%w: revisions of missing chirp %d", ErrInvalidSnapshot, chirpID)
+		}
+		for i, revision := range revisions {
+			if revision.Revision != i+1 {
+				return fmt.Errorf("%w: chirp %d has revision %d in place %d", ErrInvalidSnapshot, chirpID, revision.Revision, i+1)
+			}
+		}
+	}
+
+	for chirpID, likes := range dbStructure.Likes {
+		if _, ok := dbStructure.Chirps[chirpID]; !ok {
+			return fmt.Errorf("%w: likes of missing chirp %d", ErrInvalidSnapshot, chirpID)
+		}
+		for userID := range likes {
+			if _, ok := dbStructure.Users[userID]; !ok {
+				return fmt.Errorf("%w: chirp %d liked by missing user %d", ErrInvalidSnapshot, chirpID, userID)
+			}
+		}
+	}
+
+	for followerID, following := range dbStructure.Follows {
+		if _, ok := dbStructure.Users[followerID]; !ok {
+			return fmt.Errorf("%w: follows of missing user %d", ErrInvalidSnapshot, followerID)
+		}
+		for followeeID := range following {
+			if _, ok := dbStructure.Users[followeeID]; !ok {
+				return fmt.Errorf("%w: user %d follows missing user %d", ErrInvalidSnapshot, followerID, followeeID)
+			}
+			if followeeID == followerID {
+				return fmt.Errorf("%w: user %d follows themselves", ErrInvalidSnapshot, followerID)
+			}
+		}
+	}
+
+	for userID, chirpIDs := range dbStructure.Timelines {
+		if _, ok := dbStructure.Users[userID]; !ok {
+			return fmt.Errorf("%w: timeline of missing user %d", ErrInvalidSnapshot, userID)
+		}
+		for i, chirpID := range chirpIDs {
+			if _, ok := dbStructure.Chirps[chirpID]; !ok {
+				return fmt.Errorf("%w: timeline of user %d has missing chirp %d", ErrInvalidSnapshot, userID, chirpID)
+			}
+			if i > 0 && chirpIDs[i-1] >= chirpID {
+				return fmt.Errorf("%w: timeline of user %d is out of order", ErrInvalidSnapshot, userID)
+			}
+		}
+	}
+
+	for userID, notifications := range dbStructure.Notifications {
+		if _, ok := dbStructure.Users[userID]; !ok {
+			return fmt.Errorf("%w: notifications of missing user %d", ErrInvalidSnapshot, userID)
+		}
+		for chirpID, notification := range notifications {
+			if notification.UserID != userID || notification.ChirpID != chirpID {
+				return fmt.Errorf("%w: notification stored under the wrong key", ErrInvalidSnapshot)
+			}
+			if _, ok := dbStructure.Chirps[chirpID]; !ok {
+				return fmt.Errorf("%w: user %d notified of missing chirp %d", ErrInvalidSnapshot, userID, chirpID)
+			}
+		}
+	}
+
+	for token, refreshToken := range dbStructure.RefreshTokens {
+		if refreshToken.Token != token {
+			return fmt.Errorf("%w: refresh token stored under the wrong key", ErrInvalidSnapshot)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package database
+
+import (
+	"database/sql"
+	"sync"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SQLiteDB is a Store backed by an embedded SQLite database. The schema is
+// brought up to date by the migrations in migrations/ when it is opened.
+type SQLiteDB struct {
+	conn *sql.DB
+	feed *Feed
+	// writeMu is held by writes that publish feed events, from the write
+	// until the events are published, so they are published in the order
+	// the writes were made.
+	writeMu sync.Mutex
+
+	// searchMu guards search, which writes keep current under writeMu.
+	searchMu sync.RWMutex
+	search   *searchIndex
+
+	// fanoutThreshold is how many followers an account can have before
+	// its chirps stop being pushed into timelines; see timelines.go.
+	fanoutThreshold int
+}
+
+var _ Store = (*SQLiteDB)(nil)
+
+func NewSQLiteDB(path string) (*SQLiteDB, error) {
+	conn, err := sql.Open("sqlite3", "file:"+path+"?_foreign_keys=on&_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer at a time; one connection keeps
+	// writers queued in Go instead of failing with SQLITE_BUSY.
+	conn.SetMaxOpenConns(1)
+
+	err = migrate(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	db := &SQLiteDB{
+		conn:            conn,
+		feed:            newFeed(),
+		fanoutThreshold: DefaultFanoutThreshold,
+	}
+	err = db.rebuildSearchIndex()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func (db *SQLiteDB) Close() error {
+	db.feed.close()
+	return db.conn.Close()
+}
+
+// Feed returns the feed of changes made through this SQLiteDB.
+func (db *SQLiteDB) Feed() *Feed {
+	return db.feed
+}
+
+func (db *SQLiteDB) ResetDB() error {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = clearTables(tx)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	db.searchMu.Lock()
+	db.search = newSearchIndex()
+	db.searchMu.Unlock()
+	db.publish(Event{Kind: EventReset})
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build cgo
+
+package database
+
+import (
+	"errors"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
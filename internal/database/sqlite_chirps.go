@@ -0,0 +1,357 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// chirpColumns are the columns scanChirp reads, in order.
+const chirpColumns = `id, body, author_id, in_reply_to, rechirp_of, quoted_chirp_id, mentions, created_at, updated_at, edited_at, deleted_at`
+
+// rowScanner is satisfied by *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanChirp(row rowScanner) (Chirp, error) {
+	chirp := Chirp{}
+	var inReplyTo, rechirpOf, quotedChirpID sql.NullInt64
+	var mentions sql.NullString
+	err := row.Scan(
+		&chirp.ID, &chirp.Body, &chirp.AuthorID, &inReplyTo, &rechirpOf, &quotedChirpID, &mentions,
+		&chirp.CreatedAt, &chirp.UpdatedAt, &chirp.EditedAt, &chirp.DeletedAt,
+	)
+	if err != nil {
+		return chirp, err
+	}
+	chirp.InReplyTo = int(inReplyTo.Int64)
+	chirp.RechirpOf = int(rechirpOf.Int64)
+	chirp.QuotedChirpID = int(quotedChirpID.Int64)
+	if mentions.Valid {
+		err = json.Unmarshal([]byte(mentions.String), &chirp.Mentions)
+	}
+	return chirp, err
+}
+
+// mentionsValue stores a chirp's mentions as a JSON array, or NULL for
+// none.
+func mentionsValue(mentions []Mention) any {
+	if len(mentions) == 0 {
+		return nil
+	}
+	// A slice of plain structs always marshals.
+	dat, _ := json.Marshal(mentions)
+	return string(dat)
+}
+
+// nullIfZero stores the zero ID that means "none" as NULL.
+func nullIfZero(id int) any {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+// CreateChirp posts a chirp, checking the chirps it replies to and quotes,
+// pushing it into timelines and notifying the users it mentions in the
+// same transaction.
+func (db *SQLiteDB) CreateChirp(newChirp NewChirp) (Chirp, error) {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return Chirp{}, err
+	}
+	defer tx.Rollback()
+
+	err = activeUserTx(tx, newChirp.AuthorID)
+	if err != nil {
+		return Chirp{}, err
+	}
+	if newChirp.InReplyTo != 0 {
+		parent, err := scanChirp(tx.QueryRow(`SELECT `+chirpColumns+` FROM chirps WHERE id = ? AND deleted_at IS NULL`, newChirp.InReplyTo))
+		if errors.Is(err, sql.ErrNoRows) || err == nil && parent.RechirpOf != 0 {
+			return Chirp{}, ErrInvalidReply
+		}
+		if err != nil {
+			return Chirp{}, err
+		}
+	}
+	if newChirp.QuotedChirpID != 0 {
+		quoted, err := visibleOriginalTx(tx, newChirp.QuotedChirpID)
+		if errors.Is(err, ErrNotExist) {
+			return Chirp{}, ErrInvalidQuote
+		}
+		if err != nil {
+			return Chirp{}, err
+		}
+		newChirp.QuotedChirpID = quoted.ID
+	}
+
+	now := time.Now().UTC()
+	chirp, err := scanChirp(tx.QueryRow(
+		`INSERT INTO chirps (body, author_id, in_reply_to, quoted_chirp_id, mentions, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		RETURNING `+chirpColumns,
+		newChirp.Body, newChirp.AuthorID, nullIfZero(newChirp.InReplyTo), nullIfZero(newChirp.QuotedChirpID),
+		mentionsValue(newChirp.Mentions), now, now,
+	))
+	if err != nil {
+		return Chirp{}, err
+	}
+	err = pushChirpTx(tx, chirp, db.fanoutThreshold)
+	if err != nil {
+		return Chirp{}, err
+	}
+	err = notifyMentionsTx(tx, chirp, Chirp{})
+	if err != nil {
+		return Chirp{}, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	db.publish(Event{Kind: EventCreate, Chirp: &chirp})
+	return chirp, nil
+}
+
+func (db *SQLiteDB) GetChirps() ([]Chirp, error) {
+	return db.queryChirps(`SELECT ` + chirpColumns + ` FROM chirps WHERE deleted_at IS NULL`)
+}
+
+// inListBatch bounds the IDs in one IN list, below SQLite's limit on query
+// parameters.
+const inListBatch = 500
+
+func (db *SQLiteDB) GetChirpsByID(ids []int) (map[int]Chirp, error) {
+	chirps := map[int]Chirp{}
+	for len(ids) > 0 {
+		batch := ids[:min(len(ids), inListBatch)]
+		ids = ids[len(batch):]
+
+		args := make([]any, 0, len(batch))
+		for _, id := range batch {
+			args = append(args, id)
+		}
+		found, err := db.queryChirps(
+			`SELECT `+chirpColumns+` FROM chirps
+			WHERE id IN (?`+strings.Repeat(`, ?`, len(batch)-1)+`) AND deleted_at IS NULL`,
+			args...,
+		)
+		if err != nil {
+			return nil, err
+		}
+		for _, chirp := range found {
+			chirps[chirp.ID] = chirp
+		}
+	}
+	return chirps, nil
+}
+
+// GetChirpsByAuthor returns the author's chirps in ascending ID order.
+func (db *SQLiteDB) GetChirpsByAuthor(authorID int) ([]Chirp, error) {
+	return db.queryChirps(`SELECT `+chirpColumns+` FROM chirps WHERE author_id = ? AND deleted_at IS NULL ORDER BY id`, authorID)
+}
+
+// ListChirps returns a page of chirps ordered by ID. The ID filters and
+// position seek on the primary key; the other filters are checked row by
+// row.
+func (db *SQLiteDB) ListChirps(query ChirpQuery) (ChirpPage, error) {
+	err := query.Validate()
+	if err != nil {
+		return ChirpPage{}, err
+	}
+
+	where := []string{`deleted_at IS NULL`}
+	args := []any{}
+	if len(query.AuthorIDs) > 0 {
+		where = append(where, `author_id IN (?`+strings.Repeat(`, ?`, len(query.AuthorIDs)-1)+`)`)
+		for _, authorID := range query.AuthorIDs {
+			args = append(args, authorID)
+		}
+	}
+	if query.SinceID > 0 {
+		where = append(where, `id > ?`)
+		args = append(args, query.SinceID)
+	}
+	if query.MaxID > 0 {
+		where = append(where, `id <= ?`)
+		args = append(args, query.MaxID)
+	}
+	// Timestamps are compared as numbers, since their stored text varies
+	// in the digits of the fraction.
+	if !query.Since.IsZero() {
+		where = append(where, `unixepoch(created_at, 'subsec') >= unixepoch(?, 'subsec')`)
+		args = append(args, query.Since.UTC())
+	}
+	if !query.Until.IsZero() {
+		where = append(where, `unixepoch(created_at, 'subsec') < unixepoch(?, 'subsec')`)
+		args = append(args, query.Until.UTC())
+	}
+	if query.Contains != "" {
+		// instr matches case, like strings.Contains in the JSON store.
+		where = append(where, `instr(body, ?) > 0`)
+		args = append(args, query.Contains)
+	}
+	order := `ASC`
+	if query.Descending {
+		order = `DESC`
+	}
+	if query.AfterID > 0 {
+		if query.Descending {
+			where = append(where, `id < ?`)
+		} else {
+			where = append(where, `id > ?`)
+		}
+		args = append(args, query.AfterID)
+	}
+	stmt := `SELECT ` + chirpColumns + ` FROM chirps WHERE ` + strings.Join(where, ` AND `) + ` ORDER BY id ` + order
+	if query.Limit > 0 {
+		// Fetch one more to tell whether another page follows.
+		stmt += ` LIMIT ?`
+		args = append(args, query.Limit+1)
+	}
+
+	chirps, err := db.queryChirps(stmt, args...)
+	if err != nil {
+		return ChirpPage{}, err
+	}
+	page := ChirpPage{Chirps: chirps}
+	if query.Limit > 0 && len(chirps) > query.Limit {
+		page.Chirps = chirps[:query.Limit]
+		page.More = true
+	}
+	return page, nil
+}
+
+func (db *SQLiteDB) queryChirps(query string, args ...any) ([]Chirp, error) {
+	return queryChirpsOn(db.conn, query, args...)
+}
+
+// querier is satisfied by *sql.DB and *sql.Tx.
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+func queryChirpsOn(q querier, query string, args ...any) ([]Chirp, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chirps := []Chirp{}
+	for rows.Next() {
+		chirp, err := scanChirp(rows)
+		if err != nil {
+			return nil, err
+		}
+		chirps = append(chirps, chirp)
+	}
+	return chirps, rows.Err()
+}
+
+// queryChirp returns the chirp the query selects, or ErrNotExist.
+func (db *SQLiteDB) queryChirp(query string, args ...any) (Chirp, error) {
+	chirp, err := scanChirp(db.conn.QueryRow(query, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Chirp{}, ErrNotExist
+	}
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+func (db *SQLiteDB) GetChirp(id int) (Chirp, error) {
+	return db.queryChirp(`SELECT `+chirpColumns+` FROM chirps WHERE id = ? AND deleted_at IS NULL`, id)
+}
+
+// DeleteChirp moves the chirp to the trash. It returns ErrUnauthorized if
+// the chirp's author is in the trash.
+func (db *SQLiteDB) DeleteChirp(id int) error {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	chirp, err := scanChirp(tx.QueryRow(`SELECT `+chirpColumns+` FROM chirps WHERE id = ? AND deleted_at IS NULL`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	err = activeUserTx(tx, chirp.AuthorID)
+	if err != nil {
+		return err
+	}
+	chirp, err = scanChirp(tx.QueryRow(
+		`UPDATE chirps SET deleted_at = ? WHERE id = ? RETURNING `+chirpColumns,
+		time.Now().UTC(), id,
+	))
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	db.publish(Event{Kind: EventDelete, Chirp: &chirp})
+	return nil
+}
+
+// GetThread returns the chirp with the given ID and the replies below it,
+// down to depth levels. The whole subtree is read in one recursive query,
+// trashed chirps included, to tell which deleted chirps to keep as
+// placeholders.
+func (db *SQLiteDB) GetThread(id, depth int) (ThreadNode, error) {
+	rows, err := db.conn.Query(
+		`WITH RECURSIVE subtree(id) AS (
+			SELECT ?
+			UNION ALL
+			SELECT chirps.id FROM chirps JOIN subtree ON chirps.in_reply_to = subtree.id
+		)
+		SELECT `+chirpColumns+` FROM chirps WHERE id IN subtree ORDER BY id`,
+		id,
+	)
+	if err != nil {
+		return ThreadNode{}, err
+	}
+	defer rows.Close()
+
+	chirps := map[int]Chirp{}
+	replies := map[int][]int{}
+	for rows.Next() {
+		chirp, err := scanChirp(rows)
+		if err != nil {
+			return ThreadNode{}, err
+		}
+		chirps[chirp.ID] = chirp
+		if chirp.ID != id {
+			replies[chirp.InReplyTo] = append(replies[chirp.InReplyTo], chirp.ID)
+		}
+	}
+	if rows.Err() != nil {
+		return ThreadNode{}, rows.Err()
+	}
+
+	return buildThread(threadSource{
+		chirp: func(id int) (Chirp, bool) {
+			chirp, ok := chirps[id]
+			return chirp, ok
+		},
+		replies: func(id int) []int {
+			return replies[id]
+		},
+	}, id, depth)
+}
@@ -0,0 +1,125 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// userVisibleTx returns ErrNotExist unless the user exists and is not in
+// the trash. Lookups inside a transaction must go through it, as the pool
+// has a single connection.
+func userVisibleTx(tx *sql.Tx, id int) error {
+	var visible bool
+	err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE id = ? AND deleted_at IS NULL)`, id).Scan(&visible)
+	if err != nil {
+		return err
+	}
+	if !visible {
+		return ErrNotExist
+	}
+	return nil
+}
+
+// activeUserTx is userVisibleTx for the user a write is made on behalf of:
+// it returns ErrUnauthorized unless they exist and are not in the trash.
+func activeUserTx(tx *sql.Tx, id int) error {
+	err := userVisibleTx(tx, id)
+	if errors.Is(err, ErrNotExist) {
+		return ErrUnauthorized
+	}
+	return err
+}
+
+// FollowUser inserts the follow unless it exists, provided both users are
+// visible, and backfills the follower's timeline in one transaction.
+func (db *SQLiteDB) FollowUser(followerID, followeeID int) error {
+	if followerID == followeeID {
+		return ErrSelfFollow
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = activeUserTx(tx, followerID)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.Exec(
+		`INSERT INTO follows (follower_id, followee_id, created_at)
+		SELECT ?, ?, ? WHERE EXISTS (SELECT 1 FROM users WHERE id = ? AND deleted_at IS NULL)
+		ON CONFLICT DO NOTHING`,
+		followerID, followeeID, time.Now().UTC(), followeeID,
+	)
+	if err != nil {
+		return err
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if inserted == 0 {
+		// Either the followee is gone or the follow already existed.
+		return userVisibleTx(tx, followeeID)
+	}
+	followers, err := countFollowersTx(tx, followeeID)
+	if err != nil {
+		return err
+	}
+	if followers < db.fanoutThreshold {
+		err = backfillTimelineTx(tx, followeeID, followerID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (db *SQLiteDB) UnfollowUser(followerID, followeeID int) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = activeUserTx(tx, followerID)
+	if err != nil {
+		return err
+	}
+	err = userVisibleTx(tx, followeeID)
+	if err != nil {
+		return err
+	}
+	err = unfollowTx(tx, followerID, followeeID, db.fanoutThreshold)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetFollowSummary counts both sides in one query, joining users to leave
+// out the ones in the trash.
+func (db *SQLiteDB) GetFollowSummary(userID, viewerID int) (FollowSummary, error) {
+	summary := FollowSummary{}
+	err := db.conn.QueryRow(
+		`SELECT
+			(SELECT COUNT(*) FROM follows JOIN users ON users.id = follows.follower_id
+				WHERE follows.followee_id = u.id AND users.deleted_at IS NULL),
+			(SELECT COUNT(*) FROM follows JOIN users ON users.id = follows.followee_id
+				WHERE follows.follower_id = u.id AND users.deleted_at IS NULL),
+			EXISTS (SELECT 1 FROM follows WHERE follower_id = ? AND followee_id = u.id)
+		FROM users u WHERE u.id = ? AND u.deleted_at IS NULL`,
+		viewerID, userID,
+	).Scan(&summary.Followers, &summary.Following, &summary.FollowedByViewer)
+	if errors.Is(err, sql.ErrNoRows) {
+		return FollowSummary{}, ErrNotExist
+	}
+	if err != nil {
+		return FollowSummary{}, err
+	}
+	return summary, nil
+}
@@ -0,0 +1,127 @@
+package database
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// LikeChirp inserts the like unless it exists, provided the chirp and the
+// user are visible, in one statement.
+func (db *SQLiteDB) LikeChirp(chirpID, userID int) error {
+	res, err := db.conn.Exec(
+		`INSERT INTO likes (chirp_id, user_id, created_at)
+		SELECT ?, ?, ? WHERE EXISTS (SELECT 1 FROM chirps WHERE id = ? AND deleted_at IS NULL)
+		AND EXISTS (SELECT 1 FROM users WHERE id = ? AND deleted_at IS NULL)
+		ON CONFLICT DO NOTHING`,
+		chirpID, userID, time.Now().UTC(), chirpID, userID,
+	)
+	if err != nil {
+		return err
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if inserted == 0 {
+		// Either the user or the chirp is gone, or the like already
+		// existed.
+		return db.checkLike(chirpID, userID)
+	}
+	return nil
+}
+
+// checkLike returns the error LikeChirp and UnlikeChirp report for a like
+// of the chirp by the user: ErrUnauthorized if the user isn't visible,
+// ErrNotExist if the chirp isn't.
+func (db *SQLiteDB) checkLike(chirpID, userID int) error {
+	_, err := db.GetUser(userID)
+	if errors.Is(err, ErrNotExist) {
+		return ErrUnauthorized
+	}
+	if err != nil {
+		return err
+	}
+	_, err = db.GetChirp(chirpID)
+	return err
+}
+
+func (db *SQLiteDB) UnlikeChirp(chirpID, userID int) error {
+	err := db.checkLike(chirpID, userID)
+	if err != nil {
+		return err
+	}
+	_, err = db.conn.Exec(`DELETE FROM likes WHERE chirp_id = ? AND user_id = ?`, chirpID, userID)
+	return err
+}
+
+func (db *SQLiteDB) GetLikes(chirpID int) ([]Like, error) {
+	_, err := db.GetChirp(chirpID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.conn.Query(`SELECT chirp_id, user_id, created_at FROM likes WHERE chirp_id = ?`, chirpID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	likes := []Like{}
+	for rows.Next() {
+		like := Like{}
+		err := rows.Scan(&like.ChirpID, &like.UserID, &like.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		likes = append(likes, like)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	// Sorted in Go, as the stored times don't sort as text.
+	sortLikes(likes)
+	return likes, nil
+}
+
+func (db *SQLiteDB) GetLikeSummaries(chirpIDs []int, viewerID int) (map[int]LikeSummary, error) {
+	summaries := map[int]LikeSummary{}
+	for len(chirpIDs) > 0 {
+		batch := chirpIDs[:min(len(chirpIDs), inListBatch)]
+		chirpIDs = chirpIDs[len(batch):]
+
+		err := db.addLikeSummaries(summaries, batch, viewerID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return summaries, nil
+}
+
+func (db *SQLiteDB) addLikeSummaries(summaries map[int]LikeSummary, chirpIDs []int, viewerID int) error {
+	args := []any{viewerID}
+	for _, chirpID := range chirpIDs {
+		args = append(args, chirpID)
+	}
+	rows, err := db.conn.Query(
+		`SELECT chirp_id, COUNT(*), MAX(user_id = ?) FROM likes
+		WHERE chirp_id IN (?`+strings.Repeat(`, ?`, len(chirpIDs)-1)+`)
+		GROUP BY chirp_id`,
+		args...,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var chirpID int
+		summary := LikeSummary{}
+		err := rows.Scan(&chirpID, &summary.Count, &summary.LikedByViewer)
+		if err != nil {
+			return err
+		}
+		summaries[chirpID] = summary
+	}
+	return rows.Err()
+}
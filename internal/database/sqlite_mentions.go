@@ -0,0 +1,84 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// notifyMentionsTx brings the notifications of a chirp that was posted or
+// edited in line with its mentions, like notifyMentions. before is the
+// chirp as it was before an edit, and zero for a new chirp.
+func notifyMentionsTx(tx *sql.Tx, chirp, before Chirp) error {
+	users := mentionedUsers(chirp)
+	for userID := range mentionedUsers(before) {
+		if users[userID] {
+			continue
+		}
+		_, err := tx.Exec(`DELETE FROM notifications WHERE user_id = ? AND chirp_id = ?`, userID, chirp.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	now := time.Now().UTC()
+	for userID := range users {
+		_, err := tx.Exec(
+			`INSERT INTO notifications (user_id, chirp_id, created_at)
+			SELECT ?, ?, ? WHERE EXISTS (SELECT 1 FROM users WHERE id = ? AND deleted_at IS NULL)
+			ON CONFLICT DO NOTHING`,
+			userID, chirp.ID, now, userID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetMentions reads the page and the unread count in one read transaction.
+func (db *SQLiteDB) GetMentions(userID, afterID, limit int) (MentionPage, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return MentionPage{}, err
+	}
+	defer tx.Rollback()
+
+	page := MentionPage{}
+	err = tx.QueryRow(
+		`SELECT COUNT(*) FROM notifications JOIN chirps ON chirps.id = notifications.chirp_id
+		WHERE notifications.user_id = ? AND notifications.read_at IS NULL AND chirps.deleted_at IS NULL`,
+		userID,
+	).Scan(&page.Unread)
+	if err != nil {
+		return MentionPage{}, err
+	}
+
+	before := afterID
+	if before == 0 {
+		before = -1
+	}
+	// Fetch one more than the page to tell whether another page follows.
+	fetch := -1
+	if limit > 0 {
+		fetch = limit + 1
+	}
+	page.Chirps, err = queryChirpsOn(tx,
+		`SELECT `+chirpColumns+` FROM chirps
+		WHERE id IN (SELECT chirp_id FROM notifications WHERE user_id = ?) AND (? < 0 OR id < ?) AND deleted_at IS NULL
+		ORDER BY id DESC LIMIT ?`,
+		userID, before, before, fetch,
+	)
+	if err != nil {
+		return MentionPage{}, err
+	}
+	if limit > 0 && len(page.Chirps) > limit {
+		page.Chirps = page.Chirps[:limit]
+		page.More = true
+	}
+	return page, nil
+}
+
+func (db *SQLiteDB) MarkMentionsRead(userID int) error {
+	_, err := db.conn.Exec(`UPDATE notifications SET read_at = ? WHERE user_id = ? AND read_at IS NULL`, time.Now().UTC(), userID)
+	return err
+}
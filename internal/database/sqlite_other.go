@@ -0,0 +1,10 @@
+//go:build !cgo
+
+package database
+
+// The SQLite driver needs cgo. Without it the package still builds, so the
+// JSON store can be used and tested, but opening a SQLiteDB fails.
+
+func isUniqueViolation(err error) bool {
+	return false
+}
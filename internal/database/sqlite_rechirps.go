@@ -0,0 +1,77 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// visibleOriginalTx returns the chirp with the ID, or the chirp it shares
+// if it is a rechirp, provided that chirp is not in the trash. It returns
+// ErrNotExist otherwise.
+func visibleOriginalTx(tx *sql.Tx, id int) (Chirp, error) {
+	chirp, err := scanChirp(tx.QueryRow(`SELECT `+chirpColumns+` FROM chirps WHERE id = ?`, id))
+	if err == nil && chirp.RechirpOf != 0 {
+		chirp, err = scanChirp(tx.QueryRow(`SELECT `+chirpColumns+` FROM chirps WHERE id = ?`, chirp.RechirpOf))
+	}
+	if errors.Is(err, sql.ErrNoRows) || err == nil && chirp.DeletedAt != nil {
+		return Chirp{}, ErrNotExist
+	}
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+// RechirpChirp looks for the user's earlier rechirp and posts a new one in
+// the same transaction, so rechirping twice at once can't post two.
+func (db *SQLiteDB) RechirpChirp(chirpID, userID int) (Chirp, error) {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return Chirp{}, err
+	}
+	defer tx.Rollback()
+
+	err = activeUserTx(tx, userID)
+	if err != nil {
+		return Chirp{}, err
+	}
+	original, err := visibleOriginalTx(tx, chirpID)
+	if err != nil {
+		return Chirp{}, err
+	}
+	rechirp, err := scanChirp(tx.QueryRow(
+		`SELECT `+chirpColumns+` FROM chirps WHERE author_id = ? AND rechirp_of = ? AND deleted_at IS NULL`,
+		userID, original.ID,
+	))
+	if err == nil {
+		return rechirp, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return Chirp{}, err
+	}
+
+	now := time.Now().UTC()
+	rechirp, err = scanChirp(tx.QueryRow(
+		`INSERT INTO chirps (body, author_id, rechirp_of, created_at, updated_at) VALUES ('', ?, ?, ?, ?)
+		RETURNING `+chirpColumns,
+		userID, original.ID, now, now,
+	))
+	if err != nil {
+		return Chirp{}, err
+	}
+	err = pushChirpTx(tx, rechirp, db.fanoutThreshold)
+	if err != nil {
+		return Chirp{}, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	db.publish(Event{Kind: EventCreate, Chirp: &rechirp})
+	return rechirp, nil
+}
@@ -0,0 +1,27 @@
+package database
+
+import (
+	"time"
+)
+
+func (db *SQLiteDB) SaveRefreshToken(userID int, token string) error {
+	_, err := db.conn.Exec(
+		`INSERT INTO refresh_tokens (token, user_id, expires_at) VALUES (?, ?, ?)`,
+		token, userID, time.Now().Add(time.Hour).UTC(),
+	)
+	return err
+}
+
+func (db *SQLiteDB) RevokeRefreshToken(token string) error {
+	_, err := db.conn.Exec(`DELETE FROM refresh_tokens WHERE token = ?`, token)
+	return err
+}
+
+func (db *SQLiteDB) UserForRefreshToken(token string) (User, error) {
+	return db.queryUser(
+		`SELECT `+userColumns+` FROM users WHERE deleted_at IS NULL AND id = (
+			SELECT user_id FROM refresh_tokens WHERE token = ? AND expires_at > ?
+		)`,
+		token, time.Now().UTC(),
+	)
+}
@@ -0,0 +1,95 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// revisionColumns are the columns of a ChirpRevision, in field order.
+const revisionColumns = `revision, body, created_at, replaced_at`
+
+// EditChirp replaces the body and mentions of a chirp, keeping the old
+// body as a revision and updating its notifications, in one transaction.
+func (db *SQLiteDB) EditChirp(id int, body string, mentions []Mention) (Chirp, error) {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return Chirp{}, err
+	}
+	defer tx.Rollback()
+
+	chirp, err := scanChirp(tx.QueryRow(`SELECT `+chirpColumns+` FROM chirps WHERE id = ? AND deleted_at IS NULL`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Chirp{}, ErrNotExist
+	}
+	if err != nil {
+		return Chirp{}, err
+	}
+	err = activeUserTx(tx, chirp.AuthorID)
+	if err != nil {
+		return Chirp{}, err
+	}
+	if chirp.RechirpOf != 0 {
+		return Chirp{}, ErrRechirpEdit
+	}
+	if chirp.Body == body {
+		return chirp, nil
+	}
+
+	now := time.Now().UTC()
+	_, err = tx.Exec(
+		`INSERT INTO chirp_revisions (chirp_id, `+revisionColumns+`)
+		SELECT ?, COALESCE(MAX(revision), 0) + 1, ?, ?, ? FROM chirp_revisions WHERE chirp_id = ?`,
+		id, chirp.Body, chirp.bodyCreatedAt().UTC(), now, id,
+	)
+	if err != nil {
+		return Chirp{}, err
+	}
+	before := chirp
+	chirp, err = scanChirp(tx.QueryRow(
+		`UPDATE chirps SET body = ?, mentions = ?, edited_at = ?, updated_at = ? WHERE id = ?
+		RETURNING `+chirpColumns,
+		body, mentionsValue(mentions), now, now, id,
+	))
+	if err != nil {
+		return Chirp{}, err
+	}
+	err = notifyMentionsTx(tx, chirp, before)
+	if err != nil {
+		return Chirp{}, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	db.publish(Event{Kind: EventUpdate, Chirp: &chirp})
+	return chirp, nil
+}
+
+func (db *SQLiteDB) GetChirpRevisions(id int) ([]ChirpRevision, error) {
+	_, err := db.GetChirp(id)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.conn.Query(`SELECT `+revisionColumns+` FROM chirp_revisions WHERE chirp_id = ? ORDER BY revision`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []ChirpRevision{}
+	for rows.Next() {
+		revision := ChirpRevision{}
+		err := rows.Scan(&revision.Revision, &revision.Body, &revision.CreatedAt, &revision.ReplacedAt)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+	return revisions, rows.Err()
+}
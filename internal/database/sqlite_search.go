@@ -0,0 +1,91 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+// publish applies the chirp changes among events to the search index, then
+// publishes the events. Callers hold writeMu; after an EventReset they
+// rebuild the index themselves.
+func (db *SQLiteDB) publish(events ...Event) {
+	db.searchMu.Lock()
+	for _, event := range events {
+		if event.Chirp == nil {
+			continue
+		}
+		if event.Kind == EventDelete {
+			db.search.remove(event.Chirp.ID)
+		} else {
+			db.search.add(*event.Chirp)
+		}
+	}
+	db.searchMu.Unlock()
+
+	db.feed.publish(events...)
+}
+
+// SearchChirps returns the chirps matching query, ranked by how well they
+// match and by how recent they are. It ranks them in the in-memory search
+// index, then reads the selected page from the database.
+func (db *SQLiteDB) SearchChirps(query SearchQuery) (SearchResult, error) {
+	node, err := parseSearch(query.Query)
+	if err != nil {
+		return SearchResult{}, err
+	}
+
+	db.searchMu.RLock()
+	ids := db.search.search(node, time.Now())
+	db.searchMu.RUnlock()
+
+	result := SearchResult{
+		Chirps: []Chirp{},
+		Total:  len(ids),
+	}
+	for _, id := range pageSearchIDs(ids, query) {
+		chirp, err := db.GetChirp(id)
+		if errors.Is(err, ErrNotExist) {
+			// Deleted since it was ranked.
+			continue
+		}
+		if err != nil {
+			return SearchResult{}, err
+		}
+		result.Chirps = append(result.Chirps, chirp)
+	}
+	return result, nil
+}
+
+// RebuildSearchIndex rebuilds the search index from the chirps table and
+// returns how many chirps it indexed. Changes made to the database by
+// other processes only reach the index this way.
+func (db *SQLiteDB) RebuildSearchIndex() (int, error) {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	err := db.rebuildSearchIndex()
+	if err != nil {
+		return 0, err
+	}
+	db.searchMu.RLock()
+	defer db.searchMu.RUnlock()
+	return len(db.search.docs), nil
+}
+
+// rebuildSearchIndex replaces the search index with one built from the
+// chirps table. Callers hold writeMu, so no write slips in between.
+func (db *SQLiteDB) rebuildSearchIndex() error {
+	chirps, err := db.GetChirps()
+	if err != nil {
+		return err
+	}
+	search := newSearchIndex()
+	for _, chirp := range chirps {
+		search.add(chirp)
+	}
+
+	db.searchMu.Lock()
+	db.search = search
+	db.searchMu.Unlock()
+	return nil
+}
@@ -0,0 +1,367 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Snapshot reads the whole database in one read transaction.
+func (db *SQLiteDB) Snapshot() (DBStructure, error) {
+	dbStructure := newDBStructure()
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return DBStructure{}, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT ` + userColumns + ` FROM users`)
+	if err != nil {
+		return DBStructure{}, err
+	}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			rows.Close()
+			return DBStructure{}, err
+		}
+		dbStructure.Users[user.ID] = user
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return DBStructure{}, rows.Err()
+	}
+
+	rows, err = tx.Query(`SELECT ` + chirpColumns + ` FROM chirps`)
+	if err != nil {
+		return DBStructure{}, err
+	}
+	for rows.Next() {
+		chirp, err := scanChirp(rows)
+		if err != nil {
+			rows.Close()
+			return DBStructure{}, err
+		}
+		dbStructure.Chirps[chirp.ID] = chirp
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return DBStructure{}, rows.Err()
+	}
+
+	rows, err = tx.Query(`SELECT token, user_id, expires_at FROM refresh_tokens`)
+	if err != nil {
+		return DBStructure{}, err
+	}
+	for rows.Next() {
+		token := RefreshToken{}
+		err := rows.Scan(&token.Token, &token.UserID, &token.ExpiresAt)
+		if err != nil {
+			rows.Close()
+			return DBStructure{}, err
+		}
+		dbStructure.RefreshTokens[token.Token] = token
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return DBStructure{}, rows.Err()
+	}
+
+	rows, err = tx.Query(`SELECT chirp_id, ` + revisionColumns + ` FROM chirp_revisions ORDER BY chirp_id, revision`)
+	if err != nil {
+		return DBStructure{}, err
+	}
+	for rows.Next() {
+		var chirpID int
+		revision := ChirpRevision{}
+		err := rows.Scan(&chirpID, &revision.Revision, &revision.Body, &revision.CreatedAt, &revision.ReplacedAt)
+		if err != nil {
+			rows.Close()
+			return DBStructure{}, err
+		}
+		dbStructure.ChirpRevisions[chirpID] = append(dbStructure.ChirpRevisions[chirpID], revision)
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return DBStructure{}, rows.Err()
+	}
+
+	rows, err = tx.Query(`SELECT chirp_id, user_id, created_at FROM likes`)
+	if err != nil {
+		return DBStructure{}, err
+	}
+	for rows.Next() {
+		like := Like{}
+		err := rows.Scan(&like.ChirpID, &like.UserID, &like.CreatedAt)
+		if err != nil {
+			rows.Close()
+			return DBStructure{}, err
+		}
+		if dbStructure.Likes[like.ChirpID] == nil {
+			dbStructure.Likes[like.ChirpID] = map[int]time.Time{}
+		}
+		dbStructure.Likes[like.ChirpID][like.UserID] = like.CreatedAt
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return DBStructure{}, rows.Err()
+	}
+
+	rows, err = tx.Query(`SELECT follower_id, followee_id, created_at FROM follows`)
+	if err != nil {
+		return DBStructure{}, err
+	}
+	for rows.Next() {
+		follow := Follow{}
+		err := rows.Scan(&follow.FollowerID, &follow.FolloweeID, &follow.CreatedAt)
+		if err != nil {
+			rows.Close()
+			return DBStructure{}, err
+		}
+		if dbStructure.Follows[follow.FollowerID] == nil {
+			dbStructure.Follows[follow.FollowerID] = map[int]time.Time{}
+		}
+		dbStructure.Follows[follow.FollowerID][follow.FolloweeID] = follow.CreatedAt
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return DBStructure{}, rows.Err()
+	}
+
+	rows, err = tx.Query(`SELECT user_id, chirp_id FROM timeline_entries ORDER BY user_id, chirp_id`)
+	if err != nil {
+		return DBStructure{}, err
+	}
+	for rows.Next() {
+		entry := TimelineEntry{}
+		err := rows.Scan(&entry.UserID, &entry.ChirpID)
+		if err != nil {
+			rows.Close()
+			return DBStructure{}, err
+		}
+		dbStructure.Timelines[entry.UserID] = append(dbStructure.Timelines[entry.UserID], entry.ChirpID)
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return DBStructure{}, rows.Err()
+	}
+
+	rows, err = tx.Query(`SELECT user_id, chirp_id, created_at, read_at FROM notifications`)
+	if err != nil {
+		return DBStructure{}, err
+	}
+	for rows.Next() {
+		notification := Notification{}
+		err := rows.Scan(&notification.UserID, &notification.ChirpID, &notification.CreatedAt, &notification.ReadAt)
+		if err != nil {
+			rows.Close()
+			return DBStructure{}, err
+		}
+		if dbStructure.Notifications[notification.UserID] == nil {
+			dbStructure.Notifications[notification.UserID] = map[int]Notification{}
+		}
+		dbStructure.Notifications[notification.UserID][notification.ChirpID] = notification
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return DBStructure{}, rows.Err()
+	}
+
+	rows, err = tx.Query(`SELECT name, seq FROM sqlite_sequence`)
+	if err != nil {
+		return DBStructure{}, err
+	}
+	for rows.Next() {
+		var name string
+		var seq int
+		err := rows.Scan(&name, &seq)
+		if err != nil {
+			rows.Close()
+			return DBStructure{}, err
+		}
+		switch name {
+		case "chirps":
+			dbStructure.Sequences.Chirps = seq
+		case "users":
+			dbStructure.Sequences.Users = seq
+		}
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return DBStructure{}, rows.Err()
+	}
+
+	return dbStructure, nil
+}
+
+// Restore replaces the whole database with dbStructure in one transaction.
+func (db *SQLiteDB) Restore(dbStructure DBStructure) error {
+	err := dbStructure.Validate()
+	if err != nil {
+		return err
+	}
+
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = clearTables(tx)
+	if err != nil {
+		return err
+	}
+	err = insertStructure(tx, dbStructure)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	db.searchMu.Lock()
+	db.search = buildSearchIndex(dbStructure.Chirps)
+	db.searchMu.Unlock()
+	db.publish(Event{Kind: EventReset})
+	return nil
+}
+
+func clearTables(tx *sql.Tx) error {
+	for _, stmt := range []string{
+		`DELETE FROM refresh_tokens`,
+		`DELETE FROM likes`,
+		`DELETE FROM follows`,
+		`DELETE FROM timeline_entries`,
+		`DELETE FROM notifications`,
+		`DELETE FROM chirp_revisions`,
+		`DELETE FROM chirps`,
+		`DELETE FROM users`,
+		`DELETE FROM sqlite_sequence`,
+	} {
+		_, err := tx.Exec(stmt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertStructure inserts every record of dbStructure, keeping their IDs,
+// and raises the ID sequences to the ones it carries.
+func insertStructure(tx *sql.Tx, dbStructure DBStructure) error {
+	for _, user := range dbStructure.Users {
+		_, err := tx.Exec(
+			`INSERT INTO users (`+userColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			user.ID, user.Email, user.HashedPassword, user.IsChirpyRed,
+			user.CreatedAt.UTC(), user.UpdatedAt.UTC(), utcOrNil(user.DeletedAt),
+		)
+		if err != nil {
+			return fmt.Errorf("inserting user %d: %w", user.ID, err)
+		}
+	}
+	for _, chirp := range dbStructure.Chirps {
+		_, err := tx.Exec(
+			`INSERT INTO chirps (`+chirpColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			chirp.ID, chirp.Body, chirp.AuthorID, nullIfZero(chirp.InReplyTo),
+			nullIfZero(chirp.RechirpOf), nullIfZero(chirp.QuotedChirpID), mentionsValue(chirp.Mentions),
+			chirp.CreatedAt.UTC(), chirp.UpdatedAt.UTC(), utcOrNil(chirp.EditedAt), utcOrNil(chirp.DeletedAt),
+		)
+		if err != nil {
+			return fmt.Errorf("inserting chirp %d: %w", chirp.ID, err)
+		}
+	}
+	for chirpID, revisions := range dbStructure.ChirpRevisions {
+		for _, revision := range revisions {
+			_, err := tx.Exec(
+				`INSERT INTO chirp_revisions (chirp_id, `+revisionColumns+`) VALUES (?, ?, ?, ?, ?)`,
+				chirpID, revision.Revision, revision.Body, revision.CreatedAt.UTC(), revision.ReplacedAt.UTC(),
+			)
+			if err != nil {
+				return fmt.Errorf("inserting revision %d of chirp %d: %w", revision.Revision, chirpID, err)
+			}
+		}
+	}
+	for chirpID, likes := range dbStructure.Likes {
+		for userID, createdAt := range likes {
+			_, err := tx.Exec(
+				`INSERT INTO likes (chirp_id, user_id, created_at) VALUES (?, ?, ?)`,
+				chirpID, userID, createdAt.UTC(),
+			)
+			if err != nil {
+				return fmt.Errorf("inserting like of chirp %d by user %d: %w", chirpID, userID, err)
+			}
+		}
+	}
+	for followerID, following := range dbStructure.Follows {
+		for followeeID, createdAt := range following {
+			_, err := tx.Exec(
+				`INSERT INTO follows (follower_id, followee_id, created_at) VALUES (?, ?, ?)`,
+				followerID, followeeID, createdAt.UTC(),
+			)
+			if err != nil {
+				return fmt.Errorf("inserting follow of user %d by user %d: %w", followeeID, followerID, err)
+			}
+		}
+	}
+	for userID, chirpIDs := range dbStructure.Timelines {
+		for _, chirpID := range chirpIDs {
+			_, err := tx.Exec(`INSERT INTO timeline_entries (user_id, chirp_id) VALUES (?, ?)`, userID, chirpID)
+			if err != nil {
+				return fmt.Errorf("inserting timeline entry of chirp %d for user %d: %w", chirpID, userID, err)
+			}
+		}
+	}
+	for userID, notifications := range dbStructure.Notifications {
+		for _, notification := range notifications {
+			_, err := tx.Exec(
+				`INSERT INTO notifications (user_id, chirp_id, created_at, read_at) VALUES (?, ?, ?, ?)`,
+				userID, notification.ChirpID, notification.CreatedAt.UTC(), utcOrNil(notification.ReadAt),
+			)
+			if err != nil {
+				return fmt.Errorf("inserting notification of chirp %d for user %d: %w", notification.ChirpID, userID, err)
+			}
+		}
+	}
+	for _, token := range dbStructure.RefreshTokens {
+		_, err := tx.Exec(
+			`INSERT INTO refresh_tokens (token, user_id, expires_at) VALUES (?, ?, ?)`,
+			token.Token, token.UserID, token.ExpiresAt.UTC(),
+		)
+		if err != nil {
+			return fmt.Errorf("inserting refresh token for user %d: %w", token.UserID, err)
+		}
+	}
+
+	// Carry over IDs that were handed out and later deleted, so the SQLite
+	// sequences don't reuse them either.
+	for table, seq := range map[string]int{
+		"chirps": dbStructure.Sequences.Chirps,
+		"users":  dbStructure.Sequences.Users,
+	} {
+		_, err := tx.Exec(`UPDATE sqlite_sequence SET seq = MAX(seq, ?) WHERE name = ?`, seq, table)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(
+			`INSERT INTO sqlite_sequence (name, seq) SELECT ?, ? WHERE NOT EXISTS (SELECT 1 FROM sqlite_sequence WHERE name = ?)`,
+			table, seq, table,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// utcOrNil converts an optional time to a query argument stored in UTC, as
+// every other time is.
+func utcOrNil(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return t.UTC()
+}
@@ -0,0 +1,191 @@
+//go:build cgo
+
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func init() {
+	storeOpeners["sqlite"] = func(t *testing.T) Store {
+		db, err := NewSQLiteDB(filepath.Join(t.TempDir(), "database.db"))
+		if err != nil {
+			t.Fatalf("opening SQLite database: %v", err)
+		}
+		return db
+	}
+}
+
+// TestImportJSON checks that importing a JSON database, including the
+// changes still in its WAL, into SQLite carries every record over with
+// its ID, and that a second import is refused.
+func TestImportJSON(t *testing.T) {
+	dir := t.TempDir()
+	jsonPath := filepath.Join(dir, "database.json")
+	src, err := NewDB(jsonPath)
+	if err != nil {
+		t.Fatalf("opening JSON database: %v", err)
+	}
+	author := mustCreateUser(t, src, "author@example.com")
+	fan := mustCreateUser(t, src, "fan@example.com")
+	trashed := mustCreateUser(t, src, "trashed@example.com")
+	root := mustCreateChirp(t, src, NewChirp{Body: "hello @fan@example.com", AuthorID: author.ID, Mentions: []Mention{{UserID: fan.ID, Start: 6, End: 22}}})
+	reply := mustCreateChirp(t, src, NewChirp{Body: "reply", AuthorID: fan.ID, InReplyTo: root.ID})
+	_, err = src.EditChirp(reply.ID, "edited reply", nil)
+	if err != nil {
+		t.Fatalf("editing chirp: %v", err)
+	}
+	_, err = src.RechirpChirp(root.ID, fan.ID)
+	if err != nil {
+		t.Fatalf("rechirping: %v", err)
+	}
+	deleted := mustCreateChirp(t, src, NewChirp{Body: "deleted", AuthorID: author.ID})
+	for _, step := range []func() error{
+		func() error { return src.LikeChirp(root.ID, fan.ID) },
+		func() error { return src.FollowUser(fan.ID, author.ID) },
+		func() error { return src.SaveRefreshToken(author.ID, "token") },
+		func() error { return src.DeleteChirp(deleted.ID) },
+		func() error { return src.DeleteUser(trashed.ID) },
+	} {
+		err := step()
+		if err != nil {
+			t.Fatalf("preparing JSON database: %v", err)
+		}
+	}
+	// Flush rather than Close, so the changes are in the WAL rather than
+	// the snapshot.
+	err = src.Flush()
+	if err != nil {
+		t.Fatalf("flushing JSON database: %v", err)
+	}
+	want, err := src.Snapshot()
+	if err != nil {
+		t.Fatalf("taking snapshot of JSON database: %v", err)
+	}
+
+	dst, err := NewSQLiteDB(filepath.Join(dir, "database.db"))
+	if err != nil {
+		t.Fatalf("opening SQLite database: %v", err)
+	}
+	defer dst.Close()
+	// The JSON database is still open for writing; the import reads it
+	// alongside.
+	err = ImportJSON(jsonPath, DefaultOptions(), dst)
+	if err != nil {
+		t.Fatalf("importing: %v", err)
+	}
+	err = src.Close()
+	if err != nil {
+		t.Fatalf("closing JSON database: %v", err)
+	}
+
+	got, err := dst.Snapshot()
+	if err != nil {
+		t.Fatalf("taking snapshot of SQLite database: %v", err)
+	}
+	err = got.Validate()
+	if err != nil {
+		t.Errorf("validating imported snapshot: %v", err)
+	}
+	gotJSON, err := json.Marshal(got)
+	if err != nil {
+		t.Fatalf("encoding imported snapshot: %v", err)
+	}
+	wantJSON, err := json.Marshal(want)
+	if err != nil {
+		t.Fatalf("encoding JSON snapshot: %v", err)
+	}
+	if string(gotJSON) != string(wantJSON) {
+		t.Errorf("imported snapshot differs:\ngot  %s\nwant %s", gotJSON, wantJSON)
+	}
+
+	// New IDs continue after the imported ones.
+	user := mustCreateUser(t, dst, "late@example.com")
+	if user.ID != trashed.ID+1 {
+		t.Errorf("user created after importing got ID %d, want %d", user.ID, trashed.ID+1)
+	}
+
+	err = ImportJSON(jsonPath, DefaultOptions(), dst)
+	if !errors.Is(err, ErrNotEmpty) {
+		t.Errorf("importing into a non-empty database: got %v, want ErrNotEmpty", err)
+	}
+}
+
+// TestMigrationBackfillsEpoch checks that chirps and users from before
+// 0004_timestamps end up created at the epoch, like in the JSON store,
+// rather than at the time of the migration, and that records written with
+// a timestamp keep theirs.
+func TestMigrationBackfillsEpoch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.db")
+	conn, err := sql.Open("sqlite3", "file:"+path)
+	if err != nil {
+		t.Fatalf("opening SQLite database: %v", err)
+	}
+	_, err = conn.Exec(`CREATE TABLE schema_migrations (version INTEGER PRIMARY KEY, name TEXT NOT NULL, applied_at DATETIME)`)
+	if err != nil {
+		t.Fatalf("creating migrations table: %v", err)
+	}
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatalf("loading migrations: %v", err)
+	}
+	// Write a user and a chirp at the schema from before timestamps, then
+	// let 0004_timestamps backfill them.
+	for _, m := range migrations {
+		if m.version == 4 {
+			_, err := conn.Exec(`INSERT INTO users (email, hashed_password) VALUES ('author@example.com', 'hash');
+				INSERT INTO chirps (body, author_id) VALUES ('legacy', 1)`)
+			if err != nil {
+				t.Fatalf("writing legacy records: %v", err)
+			}
+		}
+		if m.version > 4 {
+			break
+		}
+		err := applyMigration(conn, m)
+		if err != nil {
+			t.Fatalf("applying %s: %v", m.name, err)
+		}
+	}
+	err = conn.Close()
+	if err != nil {
+		t.Fatalf("closing SQLite database: %v", err)
+	}
+
+	db, err := NewSQLiteDB(path)
+	if err != nil {
+		t.Fatalf("migrating SQLite database: %v", err)
+	}
+	defer db.Close()
+	chirp, err := db.GetChirp(1)
+	if err != nil {
+		t.Fatalf("getting legacy chirp: %v", err)
+	}
+	if !chirp.CreatedAt.Equal(backfilledAt) || !chirp.UpdatedAt.Equal(backfilledAt) {
+		t.Errorf("legacy chirp has timestamps %v and %v, want the epoch", chirp.CreatedAt, chirp.UpdatedAt)
+	}
+	user, err := db.GetUser(1)
+	if err != nil {
+		t.Fatalf("getting legacy user: %v", err)
+	}
+	if !user.CreatedAt.Equal(backfilledAt) || !user.UpdatedAt.Equal(backfilledAt) {
+		t.Errorf("legacy user has timestamps %v and %v, want the epoch", user.CreatedAt, user.UpdatedAt)
+	}
+
+	// The legacy chirp sorts before a new one.
+	created := mustCreateChirp(t, db, NewChirp{Body: "new", AuthorID: user.ID})
+	page, err := db.ListChirps(ChirpQuery{})
+	if err != nil {
+		t.Fatalf("listing chirps: %v", err)
+	}
+	if ids := chirpIDs(page.Chirps); len(ids) != 2 || ids[0] != chirp.ID || ids[1] != created.ID {
+		t.Errorf("got chirps %v, want the legacy one first", ids)
+	}
+	if created.CreatedAt.Equal(backfilledAt) {
+		t.Error("new chirp was created at the epoch")
+	}
+}
@@ -0,0 +1,179 @@
+package database
+
+import (
+	"database/sql"
+)
+
+// The timelines are maintained as in the JSON store; see timelines.go.
+
+func (db *SQLiteDB) SetFanoutThreshold(threshold int) {
+	db.fanoutThreshold = threshold
+}
+
+// pushChirpTx adds a new or restored chirp to its author's timeline and,
+// below the threshold, to their followers'.
+func pushChirpTx(tx *sql.Tx, chirp Chirp, threshold int) error {
+	_, err := tx.Exec(
+		`INSERT INTO timeline_entries (user_id, chirp_id) VALUES (?, ?) ON CONFLICT DO NOTHING`,
+		chirp.AuthorID, chirp.ID,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		`INSERT INTO timeline_entries (user_id, chirp_id)
+		SELECT follower_id, ? FROM follows
+		WHERE followee_id = ? AND (SELECT COUNT(*) FROM follows WHERE followee_id = ?) < ?
+		ON CONFLICT DO NOTHING`,
+		chirp.ID, chirp.AuthorID, chirp.AuthorID, threshold,
+	)
+	return err
+}
+
+// backfillTimelineTx adds the author's chirps that are not in the trash to
+// the timelines of the given followers of theirs, or all of them for 0.
+func backfillTimelineTx(tx *sql.Tx, authorID, followerID int) error {
+	_, err := tx.Exec(
+		`INSERT INTO timeline_entries (user_id, chirp_id)
+		SELECT follows.follower_id, chirps.id FROM follows JOIN chirps ON chirps.author_id = follows.followee_id
+		WHERE follows.followee_id = ? AND (? = 0 OR follows.follower_id = ?) AND chirps.deleted_at IS NULL
+		ON CONFLICT DO NOTHING`,
+		authorID, followerID, followerID,
+	)
+	return err
+}
+
+func countFollowersTx(tx *sql.Tx, userID int) (int, error) {
+	var followers int
+	err := tx.QueryRow(`SELECT COUNT(*) FROM follows WHERE followee_id = ?`, userID).Scan(&followers)
+	return followers, err
+}
+
+// unfollowTx removes a follow and the followee's chirps from the
+// follower's timeline. If that takes the followee below the threshold,
+// their chirps are backfilled into their remaining followers' timelines.
+func unfollowTx(tx *sql.Tx, followerID, followeeID, threshold int) error {
+	followers, err := countFollowersTx(tx, followeeID)
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec(`DELETE FROM follows WHERE follower_id = ? AND followee_id = ?`, followerID, followeeID)
+	if err != nil {
+		return err
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil || deleted == 0 {
+		return err
+	}
+	_, err = tx.Exec(
+		`DELETE FROM timeline_entries
+		WHERE user_id = ? AND chirp_id IN (SELECT id FROM chirps WHERE author_id = ?)`,
+		followerID, followeeID,
+	)
+	if err != nil {
+		return err
+	}
+	if followers == threshold {
+		return backfillTimelineTx(tx, followeeID, 0)
+	}
+	return nil
+}
+
+// GetTimeline reads the page from the user's materialized timeline and
+// from the chirps of the users they follow at or above the threshold, each
+// seeking down its index from afterID, and merges the two.
+func (db *SQLiteDB) GetTimeline(userID, afterID, limit int) (ChirpPage, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return ChirpPage{}, err
+	}
+	defer tx.Rollback()
+
+	before := afterID
+	if before == 0 {
+		before = -1
+	}
+	// Fetch one more than the page to tell whether another page follows.
+	fetch := -1
+	if limit > 0 {
+		fetch = limit + 1
+	}
+	pushed, err := queryChirpsOn(tx,
+		`SELECT `+chirpColumns+` FROM timeline_entries JOIN chirps ON chirps.id = timeline_entries.chirp_id
+		WHERE timeline_entries.user_id = ? AND (? < 0 OR timeline_entries.chirp_id < ?) AND chirps.deleted_at IS NULL
+		ORDER BY timeline_entries.chirp_id DESC LIMIT ?`,
+		userID, before, before, fetch,
+	)
+	if err != nil {
+		return ChirpPage{}, err
+	}
+	pulled, err := queryChirpsOn(tx,
+		`SELECT `+chirpColumns+` FROM chirps
+		WHERE author_id IN (
+			SELECT followee_id FROM follows
+			WHERE follower_id = ? AND (SELECT COUNT(*) FROM follows AS others WHERE others.followee_id = follows.followee_id) >= ?
+		) AND (? < 0 OR id < ?) AND deleted_at IS NULL
+		ORDER BY id DESC LIMIT ?`,
+		userID, db.fanoutThreshold, before, before, fetch,
+	)
+	if err != nil {
+		return ChirpPage{}, err
+	}
+
+	page := ChirpPage{
+		Chirps: []Chirp{},
+	}
+	i, j := 0, 0
+	for i < len(pushed) || j < len(pulled) {
+		var chirp Chirp
+		switch {
+		case j == len(pulled) || i < len(pushed) && pushed[i].ID > pulled[j].ID:
+			chirp = pushed[i]
+			i++
+		case i == len(pushed) || pulled[j].ID > pushed[i].ID:
+			chirp = pulled[j]
+			j++
+		default:
+			chirp = pushed[i]
+			i++
+			j++
+		}
+		if limit > 0 && len(page.Chirps) == limit {
+			page.More = true
+			break
+		}
+		page.Chirps = append(page.Chirps, chirp)
+	}
+	return page, nil
+}
+
+// RebuildTimelines replaces every timeline entry in one transaction.
+func (db *SQLiteDB) RebuildTimelines() (int, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM timeline_entries`)
+	if err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec(
+		`INSERT INTO timeline_entries (user_id, chirp_id)
+		SELECT author_id, id FROM chirps WHERE deleted_at IS NULL
+		UNION
+		SELECT follows.follower_id, chirps.id FROM follows JOIN chirps ON chirps.author_id = follows.followee_id
+		WHERE chirps.deleted_at IS NULL
+		AND (SELECT COUNT(*) FROM follows AS others WHERE others.followee_id = follows.followee_id) < ?`,
+		db.fanoutThreshold,
+	)
+	if err != nil {
+		return 0, err
+	}
+	total, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(total), tx.Commit()
+}
@@ -0,0 +1,166 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+func (db *SQLiteDB) ListTrash() (Trash, error) {
+	chirps, err := db.queryChirps(`SELECT ` + chirpColumns + ` FROM chirps WHERE deleted_at IS NOT NULL ORDER BY id`)
+	if err != nil {
+		return Trash{}, err
+	}
+
+	rows, err := db.conn.Query(`SELECT ` + userColumns + ` FROM users WHERE deleted_at IS NOT NULL ORDER BY id`)
+	if err != nil {
+		return Trash{}, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return Trash{}, err
+		}
+		users = append(users, user)
+	}
+	if rows.Err() != nil {
+		return Trash{}, rows.Err()
+	}
+
+	return Trash{
+		Chirps: chirps,
+		Users:  users,
+	}, nil
+}
+
+func (db *SQLiteDB) RestoreChirp(id int) error {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	chirp, err := scanChirp(tx.QueryRow(
+		`UPDATE chirps SET deleted_at = NULL WHERE id = ? AND deleted_at IS NOT NULL
+		RETURNING `+chirpColumns,
+		id,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotExist
+	}
+	if err != nil {
+		return err
+	}
+	err = pushChirpTx(tx, chirp, db.fanoutThreshold)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	db.publish(Event{Kind: EventCreate, Chirp: &chirp})
+	return nil
+}
+
+func (db *SQLiteDB) RestoreUser(id int) error {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	user, err := db.queryUser(
+		`UPDATE users SET deleted_at = NULL WHERE id = ? AND deleted_at IS NOT NULL
+		RETURNING `+userColumns,
+		id,
+	)
+	if err != nil {
+		return err
+	}
+	db.publish(Event{Kind: EventCreate, User: &user})
+	return nil
+}
+
+// PurgeTrash permanently removes the chirps and users that were deleted
+// before cutoff, along with the purged chirps' revisions, the purged
+// users' refresh tokens, follows and timelines, and the likes, timeline
+// entries and notifications of both. They left the feed when they were
+// trashed, so purging them publishes nothing.
+func (db *SQLiteDB) PurgeTrash(cutoff time.Time) (int, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	cutoff = cutoff.UTC()
+	for _, stmt := range []string{
+		`DELETE FROM refresh_tokens WHERE user_id IN (SELECT id FROM users WHERE deleted_at < ?)`,
+		`DELETE FROM chirp_revisions WHERE chirp_id IN (SELECT id FROM chirps WHERE deleted_at < ?)`,
+		`DELETE FROM likes WHERE chirp_id IN (SELECT id FROM chirps WHERE deleted_at < ?)`,
+		`DELETE FROM likes WHERE user_id IN (SELECT id FROM users WHERE deleted_at < ?)`,
+		`DELETE FROM timeline_entries WHERE chirp_id IN (SELECT id FROM chirps WHERE deleted_at < ?)`,
+		`DELETE FROM notifications WHERE chirp_id IN (SELECT id FROM chirps WHERE deleted_at < ?)`,
+		`DELETE FROM notifications WHERE user_id IN (SELECT id FROM users WHERE deleted_at < ?)`,
+	} {
+		_, err = tx.Exec(stmt, cutoff)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	rows, err := tx.Query(
+		`SELECT follower_id, followee_id FROM follows
+		WHERE follower_id IN (SELECT id FROM users WHERE deleted_at < ?)
+		OR followee_id IN (SELECT id FROM users WHERE deleted_at < ?)`,
+		cutoff, cutoff,
+	)
+	if err != nil {
+		return 0, err
+	}
+	follows := []Follow{}
+	for rows.Next() {
+		follow := Follow{}
+		err := rows.Scan(&follow.FollowerID, &follow.FolloweeID)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		follows = append(follows, follow)
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return 0, rows.Err()
+	}
+	for _, follow := range follows {
+		err := unfollowTx(tx, follow.FollowerID, follow.FolloweeID, db.fanoutThreshold)
+		if err != nil {
+			return 0, err
+		}
+	}
+	_, err = tx.Exec(`DELETE FROM timeline_entries WHERE user_id IN (SELECT id FROM users WHERE deleted_at < ?)`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, stmt := range []string{
+		`DELETE FROM chirps WHERE deleted_at < ?`,
+		`DELETE FROM users WHERE deleted_at < ?`,
+	} {
+		res, err := tx.Exec(stmt, cutoff)
+		if err != nil {
+			return 0, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		purged += int(n)
+	}
+	return purged, tx.Commit()
+}
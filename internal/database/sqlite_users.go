@@ -0,0 +1,146 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// userColumns are the columns scanUser reads, in order.
+const userColumns = `id, email, hashed_password, is_chirpy_red, created_at, updated_at, deleted_at`
+
+func scanUser(row rowScanner) (User, error) {
+	user := User{}
+	err := row.Scan(&user.ID, &user.Email, &user.HashedPassword, &user.IsChirpyRed, &user.CreatedAt, &user.UpdatedAt, &user.DeletedAt)
+	return user, err
+}
+
+func (db *SQLiteDB) CreateUser(email string, hashedPassword string) (User, error) {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	now := time.Now().UTC()
+	res, err := db.conn.Exec(
+		`INSERT INTO users (email, hashed_password, created_at, updated_at) VALUES (?, ?, ?, ?)`,
+		email, hashedPassword, now, now,
+	)
+	if isUniqueViolation(err) {
+		return User{}, ErrAlreadyExists
+	}
+	if err != nil {
+		return User{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return User{}, err
+	}
+
+	user := User{
+		ID:             int(id),
+		Email:          email,
+		HashedPassword: hashedPassword,
+		IsChirpyRed:    false,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	db.publish(Event{Kind: EventCreate, User: &user})
+	return user, nil
+}
+
+// GetUserByEmail looks a user up by email, ignoring case.
+func (db *SQLiteDB) GetUserByEmail(email string) (User, error) {
+	return db.queryUser(`SELECT `+userColumns+` FROM users WHERE email = ? COLLATE NOCASE AND deleted_at IS NULL`, email)
+}
+
+func (db *SQLiteDB) GetUser(id int) (User, error) {
+	return db.queryUser(`SELECT `+userColumns+` FROM users WHERE id = ? AND deleted_at IS NULL`, id)
+}
+
+func (db *SQLiteDB) UpdateUser(id int, email, hashedPassword string) (User, error) {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	user, err := db.queryUser(
+		`UPDATE users SET email = ?, hashed_password = ?, updated_at = ? WHERE id = ? AND deleted_at IS NULL
+		RETURNING `+userColumns,
+		email, hashedPassword, time.Now().UTC(), id,
+	)
+	if isUniqueViolation(err) {
+		return User{}, ErrAlreadyExists
+	}
+	if err != nil {
+		return User{}, err
+	}
+	db.publish(Event{Kind: EventUpdate, User: &user})
+	return user, nil
+}
+
+// UpdateUserIsChirpyRed upgrades the user to Chirpy Red. Upgrading a user
+// who already has it changes nothing.
+func (db *SQLiteDB) UpdateUserIsChirpyRed(id int) error {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	user, err := db.queryUser(
+		`UPDATE users SET is_chirpy_red = TRUE, updated_at = ? WHERE id = ? AND deleted_at IS NULL AND NOT is_chirpy_red
+		RETURNING `+userColumns,
+		time.Now().UTC(), id,
+	)
+	if errors.Is(err, ErrNotExist) {
+		// Either there is no such user, or they are already upgraded.
+		_, err = db.GetUser(id)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	db.publish(Event{Kind: EventUpdate, User: &user})
+	return nil
+}
+
+// DeleteUser moves the user to the trash and revokes their refresh tokens
+// in one transaction. Their email stays reserved.
+func (db *SQLiteDB) DeleteUser(id int) error {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	user, err := scanUser(tx.QueryRow(
+		`UPDATE users SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL
+		RETURNING `+userColumns,
+		time.Now().UTC(), id,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotExist
+	}
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM refresh_tokens WHERE user_id = ?`, id)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	db.publish(Event{Kind: EventDelete, User: &user})
+	return nil
+}
+
+// queryUser returns the user the query selects, or ErrNotExist.
+func (db *SQLiteDB) queryUser(query string, args ...any) (User, error) {
+	user, err := scanUser(db.conn.QueryRow(query, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrNotExist
+	}
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
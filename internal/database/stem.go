@@ -0,0 +1,135 @@
+package database
+
+import "strings"
+
+// stem reduces an English word to its stem by the steps of the Porter
+// stemming algorithm that remove inflections: plurals, -ed and -ing, and a
+// final e. "Posts", "posted" and "posting" all become "post", and
+// "believe" and "believing" become "believ". Words that aren't
+// plain lowercase ASCII letters are returned as they are.
+func stem(word string) string {
+	if len(word) <= 2 {
+		return word
+	}
+	for i := 0; i < len(word); i++ {
+		if word[i] < 'a' || word[i] > 'z' {
+			return word
+		}
+	}
+
+	// Step 1a: plurals.
+	switch {
+	case strings.HasSuffix(word, "sses"):
+		word = word[:len(word)-2]
+	case strings.HasSuffix(word, "ies"):
+		word = word[:len(word)-2]
+	case strings.HasSuffix(word, "ss"):
+	case strings.HasSuffix(word, "s"):
+		word = word[:len(word)-1]
+	}
+
+	// Step 1b: -ed and -ing.
+	switch {
+	case strings.HasSuffix(word, "eed"):
+		if measure(word[:len(word)-3]) > 0 {
+			word = word[:len(word)-1]
+		}
+	case strings.HasSuffix(word, "ed") && hasVowel(word[:len(word)-2]):
+		word = restoreStem(word[:len(word)-2])
+	case strings.HasSuffix(word, "ing") && hasVowel(word[:len(word)-3]):
+		word = restoreStem(word[:len(word)-3])
+	}
+
+	// Step 1c: a final y becomes i if the rest has a vowel, so "city" and
+	// "cities" meet at "citi".
+	if strings.HasSuffix(word, "y") && hasVowel(word[:len(word)-1]) {
+		word = word[:len(word)-1] + "i"
+	}
+
+	// Step 5a: a final e goes, unless the stem is short and ends like
+	// "hop", so "foxes" meets "fox" while "hope" stays apart from it.
+	if strings.HasSuffix(word, "e") {
+		m := measure(word[:len(word)-1])
+		if m > 1 || m == 1 && !endsWithCVC(word[:len(word)-1]) {
+			word = word[:len(word)-1]
+		}
+	}
+	return word
+}
+
+// restoreStem tidies a stem whose -ed or -ing was removed: "conflat" gets
+// its e back, "hopp" loses a p, and "hop" (from "hoping") becomes "hope".
+func restoreStem(word string) string {
+	switch {
+	case strings.HasSuffix(word, "at"), strings.HasSuffix(word, "bl"), strings.HasSuffix(word, "iz"):
+		return word + "e"
+	case endsWithDoubleConsonant(word):
+		last := word[len(word)-1]
+		if last != 'l' && last != 's' && last != 'z' {
+			return word[:len(word)-1]
+		}
+	case measure(word) == 1 && endsWithCVC(word):
+		return word + "e"
+	}
+	return word
+}
+
+// isConsonant reports whether word[i] is a consonant: a letter other than
+// a, e, i, o and u, and other than a y following a consonant.
+func isConsonant(word string, i int) bool {
+	switch word[i] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return false
+	case 'y':
+		return i == 0 || !isConsonant(word, i-1)
+	}
+	return true
+}
+
+// measure counts the vowel-consonant sequences in word, m in the Porter
+// algorithm's [C](VC){m}[V].
+func measure(word string) int {
+	m := 0
+	i := 0
+	for i < len(word) && isConsonant(word, i) {
+		i++
+	}
+	for i < len(word) {
+		for i < len(word) && !isConsonant(word, i) {
+			i++
+		}
+		if i == len(word) {
+			break
+		}
+		for i < len(word) && isConsonant(word, i) {
+			i++
+		}
+		m++
+	}
+	return m
+}
+
+func hasVowel(word string) bool {
+	for i := range word {
+		if !isConsonant(word, i) {
+			return true
+		}
+	}
+	return false
+}
+
+func endsWithDoubleConsonant(word string) bool {
+	n := len(word)
+	return n >= 2 && word[n-1] == word[n-2] && isConsonant(word, n-1)
+}
+
+// endsWithCVC reports whether word ends consonant-vowel-consonant with the
+// last consonant not w, x or y, as in "hop" but not "snow".
+func endsWithCVC(word string) bool {
+	n := len(word)
+	if n < 3 || !isConsonant(word, n-3) || isConsonant(word, n-2) || !isConsonant(word, n-1) {
+		return false
+	}
+	last := word[n-1]
+	return last != 'w' && last != 'x' && last != 'y'
+}
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"time"
+)
+
+// Store is the set of persistence operations the API handlers depend on.
+// *DB, backed by a single JSON file, is one implementation.
+type Store interface {
+	CreateChirp(newChirp NewChirp) (Chirp, error)
+	GetChirps() ([]Chirp, error)
+	GetChirp(id int) (Chirp, error)
+	// GetChirpsByID returns the chirps with the IDs that exist and are not
+	// in the trash, keyed by ID.
+	GetChirpsByID(ids []int) (map[int]Chirp, error)
+	// RechirpChirp shares a chirp as the user, returning their rechirp.
+	RechirpChirp(chirpID, userID int) (Chirp, error)
+	GetChirpsByAuthor(authorID int) ([]Chirp, error)
+	// GetThread returns a chirp and the replies below it, down to depth
+	// levels.
+	GetThread(id, depth int) (ThreadNode, error)
+	// ListChirps returns the page of chirps query selects.
+	ListChirps(query ChirpQuery) (ChirpPage, error)
+	// DeleteChirp moves a chirp to the trash, unless its author is in the
+	// trash.
+	DeleteChirp(id int) error
+	// EditChirp replaces a chirp's body and mentions, keeping the old body
+	// as a revision. GetChirpRevisions returns the revisions, oldest first.
+	EditChirp(id int, body string, mentions []Mention) (Chirp, error)
+	GetChirpRevisions(id int) ([]ChirpRevision, error)
+	// SearchChirps returns the chirps matching a full-text query, best
+	// first. RebuildSearchIndex rebuilds the index it searches from
+	// scratch and returns how many chirps it indexed.
+	SearchChirps(query SearchQuery) (SearchResult, error)
+	RebuildSearchIndex() (int, error)
+
+	// LikeChirp and UnlikeChirp add and remove a user's like of a chirp;
+	// both are idempotent. GetLikes returns a chirp's likes, newest first,
+	// and GetLikeSummaries counts the likes of several chirps at once.
+	LikeChirp(chirpID, userID int) error
+	UnlikeChirp(chirpID, userID int) error
+	GetLikes(chirpID int) ([]Like, error)
+	GetLikeSummaries(chirpIDs []int, viewerID int) (map[int]LikeSummary, error)
+
+	// FollowUser and UnfollowUser add and remove a follow between two
+	// users; both are idempotent. GetFollowSummary counts a user's
+	// followers and followings, and GetTimeline returns a page of a user's
+	// home timeline, newest first.
+	FollowUser(followerID, followeeID int) error
+	UnfollowUser(followerID, followeeID int) error
+	GetFollowSummary(userID, viewerID int) (FollowSummary, error)
+	GetTimeline(userID, afterID, limit int) (ChirpPage, error)
+	// RebuildTimelines recomputes the materialized home timelines and
+	// returns how many entries they hold. SetFanoutThreshold sets how many
+	// followers an account can have before its chirps are read into
+	// timelines instead of pushed; it must be called before the store is
+	// used.
+	RebuildTimelines() (int, error)
+	SetFanoutThreshold(threshold int)
+
+	// GetMentions returns a page of the chirps mentioning a user, newest
+	// first, with how many of their mentions are unread, and
+	// MarkMentionsRead marks them all read.
+	GetMentions(userID, afterID, limit int) (MentionPage, error)
+	MarkMentionsRead(userID int) error
+
+	CreateUser(email string, hashedPassword string) (User, error)
+	GetUserByEmail(email string) (User, error)
+	GetUser(id int) (User, error)
+	UpdateUser(id int, email, hashedPassword string) (User, error)
+	UpdateUserIsChirpyRed(id int) error
+	// DeleteUser moves a user to the trash and revokes their refresh
+	// tokens.
+	DeleteUser(id int) error
+
+	// ListTrash returns the deleted chirps and users that haven't been
+	// purged yet. RestoreChirp and RestoreUser take them back out.
+	ListTrash() (Trash, error)
+	RestoreChirp(id int) error
+	RestoreUser(id int) error
+	// PurgeTrash permanently removes what was deleted before cutoff and
+	// returns how many chirps and users it removed.
+	PurgeTrash(cutoff time.Time) (int, error)
+
+	SaveRefreshToken(userID int, token string) error
+	RevokeRefreshToken(token string) error
+	UserForRefreshToken(token string) (User, error)
+
+	// Snapshot returns a consistent copy of the whole database.
+	Snapshot() (DBStructure, error)
+	// Restore validates dbStructure and replaces the whole database with it.
+	Restore(dbStructure DBStructure) error
+
+	// Feed returns the feed of changes to chirps and users.
+	Feed() *Feed
+
+	ResetDB() error
+	// Close releases the store. Pending writes are persisted first.
+	Close() error
+}
+
+var _ Store = (*DB)(nil)
@@ -0,0 +1,381 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// storeOpeners open an empty store of each backend for the tests that run
+// against every Store. The SQLite backend adds itself in
+// sqlite_store_test.go, which needs cgo.
+var storeOpeners = map[string]func(t *testing.T) Store{
+	"json": func(t *testing.T) Store {
+		db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+		if err != nil {
+			t.Fatalf("opening JSON database: %v", err)
+		}
+		return db
+	},
+}
+
+// forEachStore runs test against an empty store of each backend.
+func forEachStore(t *testing.T, test func(t *testing.T, store Store)) {
+	names := make([]string, 0, len(storeOpeners))
+	for name := range storeOpeners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		t.Run(name, func(t *testing.T) {
+			store := storeOpeners[name](t)
+			defer func() {
+				err := store.Close()
+				if err != nil {
+					t.Errorf("closing store: %v", err)
+				}
+			}()
+			test(t, store)
+		})
+	}
+}
+
+func mustCreateUser(t *testing.T, store Store, email string) User {
+	t.Helper()
+	user, err := store.CreateUser(email, "hash")
+	if err != nil {
+		t.Fatalf("creating user %s: %v", email, err)
+	}
+	return user
+}
+
+func mustCreateChirp(t *testing.T, store Store, newChirp NewChirp) Chirp {
+	t.Helper()
+	chirp, err := store.CreateChirp(newChirp)
+	if err != nil {
+		t.Fatalf("creating chirp %+v: %v", newChirp, err)
+	}
+	return chirp
+}
+
+func chirpIDs(chirps []Chirp) []int {
+	ids := []int{}
+	for _, chirp := range chirps {
+		ids = append(ids, chirp.ID)
+	}
+	return ids
+}
+
+func TestStoreCreateAndGet(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		user := mustCreateUser(t, store, "Author@Example.com")
+		if user.ID != 1 || user.Email != "Author@Example.com" || user.CreatedAt.IsZero() {
+			t.Errorf("created user %+v", user)
+		}
+		_, err := store.CreateUser("author@example.com", "hash")
+		if !errors.Is(err, ErrAlreadyExists) {
+			t.Errorf("creating a user with the same email: got %v, want ErrAlreadyExists", err)
+		}
+
+		got, err := store.GetUser(user.ID)
+		if err != nil || got.Email != user.Email {
+			t.Errorf("getting user %d: got %+v, %v", user.ID, got, err)
+		}
+		got, err = store.GetUserByEmail("author@example.com")
+		if err != nil || got.ID != user.ID {
+			t.Errorf("getting user by email: got %+v, %v", got, err)
+		}
+		_, err = store.GetUser(user.ID + 1)
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("getting a missing user: got %v, want ErrNotExist", err)
+		}
+
+		chirp := mustCreateChirp(t, store, NewChirp{Body: "hello", AuthorID: user.ID})
+		if chirp.ID != 1 || chirp.Body != "hello" || chirp.AuthorID != user.ID || chirp.CreatedAt.IsZero() {
+			t.Errorf("created chirp %+v", chirp)
+		}
+		gotChirp, err := store.GetChirp(chirp.ID)
+		if err != nil || gotChirp.Body != chirp.Body || !gotChirp.CreatedAt.Equal(chirp.CreatedAt) {
+			t.Errorf("getting chirp %d: got %+v, %v", chirp.ID, gotChirp, err)
+		}
+		_, err = store.GetChirp(chirp.ID + 1)
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("getting a missing chirp: got %v, want ErrNotExist", err)
+		}
+		_, err = store.CreateChirp(NewChirp{Body: "orphan", AuthorID: user.ID + 1})
+		if !errors.Is(err, ErrUnauthorized) {
+			t.Errorf("creating a chirp by a missing user: got %v, want ErrUnauthorized", err)
+		}
+		_, err = store.CreateChirp(NewChirp{Body: "reply", AuthorID: user.ID, InReplyTo: chirp.ID + 1})
+		if !errors.Is(err, ErrInvalidReply) {
+			t.Errorf("replying to a missing chirp: got %v, want ErrInvalidReply", err)
+		}
+
+		byAuthor, err := store.GetChirpsByAuthor(user.ID)
+		if err != nil || !reflect.DeepEqual(chirpIDs(byAuthor), []int{chirp.ID}) {
+			t.Errorf("getting chirps by author: got %v, %v", chirpIDs(byAuthor), err)
+		}
+	})
+}
+
+func TestStoreListChirps(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		alice := mustCreateUser(t, store, "alice@example.com")
+		bob := mustCreateUser(t, store, "bob@example.com")
+		// Chirps 1 to 6 alternate between the authors, a few milliseconds
+		// apart so the time filters can tell them apart.
+		chirps := []Chirp{}
+		for i := 1; i <= 6; i++ {
+			author := alice
+			if i%2 == 0 {
+				author = bob
+			}
+			chirps = append(chirps, mustCreateChirp(t, store, NewChirp{
+				Body:     fmt.Sprintf("chirp %d by %s", i, author.Email),
+				AuthorID: author.ID,
+			}))
+			time.Sleep(5 * time.Millisecond)
+		}
+		err := store.DeleteChirp(chirps[4].ID)
+		if err != nil {
+			t.Fatalf("deleting chirp: %v", err)
+		}
+
+		tests := []struct {
+			name  string
+			query ChirpQuery
+			want  []int
+			more  bool
+		}{
+			{"all", ChirpQuery{}, []int{1, 2, 3, 4, 6}, false},
+			{"descending", ChirpQuery{Descending: true}, []int{6, 4, 3, 2, 1}, false},
+			{"author", ChirpQuery{AuthorIDs: []int{alice.ID}}, []int{1, 3}, false},
+			{"authors", ChirpQuery{AuthorIDs: []int{alice.ID, bob.ID}}, []int{1, 2, 3, 4, 6}, false},
+			{"ID range", ChirpQuery{SinceID: 1, MaxID: 4}, []int{2, 3, 4}, false},
+			{"time range", ChirpQuery{Since: chirps[1].CreatedAt, Until: chirps[3].CreatedAt}, []int{2, 3}, false},
+			{"contains", ChirpQuery{Contains: "bob"}, []int{2, 4, 6}, false},
+			{"contains matches case", ChirpQuery{Contains: "Bob"}, []int{}, false},
+			{"first page", ChirpQuery{Limit: 2}, []int{1, 2}, true},
+			{"next page", ChirpQuery{Limit: 2, AfterID: 2}, []int{3, 4}, true},
+			{"last page", ChirpQuery{Limit: 2, AfterID: 4}, []int{6}, false},
+			{"first descending page", ChirpQuery{Descending: true, Limit: 3}, []int{6, 4, 3}, true},
+			{"last descending page", ChirpQuery{Descending: true, Limit: 3, AfterID: 3}, []int{2, 1}, false},
+			{"page of filtered chirps", ChirpQuery{AuthorIDs: []int{bob.ID}, Limit: 1, AfterID: 2}, []int{4}, true},
+		}
+		for _, tt := range tests {
+			page, err := store.ListChirps(tt.query)
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+				continue
+			}
+			if got := chirpIDs(page.Chirps); !reflect.DeepEqual(got, tt.want) || page.More != tt.more {
+				t.Errorf("%s: got %v, more %v, want %v, more %v", tt.name, got, page.More, tt.want, tt.more)
+			}
+		}
+
+		for _, query := range []ChirpQuery{
+			{MaxID: 2, SinceID: 2},
+			{AuthorIDs: []int{0}},
+			{Limit: -1},
+			{Since: chirps[1].CreatedAt, Until: chirps[0].CreatedAt},
+		} {
+			_, err := store.ListChirps(query)
+			if !errors.Is(err, ErrInvalidQuery) {
+				t.Errorf("listing %+v: got %v, want ErrInvalidQuery", query, err)
+			}
+		}
+	})
+}
+
+// threadShape renders a thread as nested IDs, with placeholders marked by
+// a trailing "?", so whole threads compare at once.
+func threadShape(node ThreadNode) string {
+	shape := fmt.Sprint(node.ID)
+	if node.Chirp == nil {
+		shape += "?"
+	}
+	if len(node.Replies) > 0 {
+		shape += "["
+		for i, reply := range node.Replies {
+			if i > 0 {
+				shape += " "
+			}
+			shape += threadShape(reply)
+		}
+		shape += "]"
+	}
+	return shape
+}
+
+func TestStoreDeleteChirpThread(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		user := mustCreateUser(t, store, "author@example.com")
+		// 1 has the replies 2 and 3, and 2 has the reply 4.
+		root := mustCreateChirp(t, store, NewChirp{Body: "root", AuthorID: user.ID})
+		reply := mustCreateChirp(t, store, NewChirp{Body: "reply", AuthorID: user.ID, InReplyTo: root.ID})
+		other := mustCreateChirp(t, store, NewChirp{Body: "other reply", AuthorID: user.ID, InReplyTo: root.ID})
+		mustCreateChirp(t, store, NewChirp{Body: "nested reply", AuthorID: user.ID, InReplyTo: reply.ID})
+
+		threadOf := func(id int) string {
+			t.Helper()
+			thread, err := store.GetThread(id, 10)
+			if err != nil {
+				t.Fatalf("getting thread of %d: %v", id, err)
+			}
+			return threadShape(thread)
+		}
+		if got := threadOf(root.ID); got != "1[2[4] 3]" {
+			t.Errorf("thread before deleting: got %s", got)
+		}
+
+		for _, id := range []int{reply.ID, other.ID} {
+			err := store.DeleteChirp(id)
+			if err != nil {
+				t.Fatalf("deleting chirp %d: %v", id, err)
+			}
+		}
+		_, err := store.GetChirp(reply.ID)
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("getting a deleted chirp: got %v, want ErrNotExist", err)
+		}
+		// The deleted reply with a visible reply stays as a placeholder;
+		// the one without is left out.
+		if got := threadOf(root.ID); got != "1[2?[4]]" {
+			t.Errorf("thread after deleting: got %s", got)
+		}
+		_, err = store.GetThread(other.ID, 10)
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("getting the thread of a deleted chirp without replies: got %v, want ErrNotExist", err)
+		}
+		_, err = store.CreateChirp(NewChirp{Body: "late reply", AuthorID: user.ID, InReplyTo: reply.ID})
+		if !errors.Is(err, ErrInvalidReply) {
+			t.Errorf("replying to a deleted chirp: got %v, want ErrInvalidReply", err)
+		}
+
+		err = store.RestoreChirp(other.ID)
+		if err != nil {
+			t.Fatalf("restoring chirp: %v", err)
+		}
+		if got := threadOf(root.ID); got != "1[2?[4] 3]" {
+			t.Errorf("thread after restoring: got %s", got)
+		}
+	})
+}
+
+func TestStoreDeleteChirpByTrashedAuthor(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		author := mustCreateUser(t, store, "author@example.com")
+		chirp := mustCreateChirp(t, store, NewChirp{Body: "hello", AuthorID: author.ID})
+		err := store.DeleteUser(author.ID)
+		if err != nil {
+			t.Fatalf("deleting author: %v", err)
+		}
+
+		err = store.DeleteChirp(chirp.ID)
+		if !errors.Is(err, ErrUnauthorized) {
+			t.Errorf("deleting chirp of a trashed author: got %v, want ErrUnauthorized", err)
+		}
+		err = store.RestoreUser(author.ID)
+		if err != nil {
+			t.Fatalf("restoring author: %v", err)
+		}
+		_, err = store.GetChirp(chirp.ID)
+		if err != nil {
+			t.Errorf("getting chirp after the refused delete: %v", err)
+		}
+		err = store.DeleteChirp(chirp.ID)
+		if err != nil {
+			t.Errorf("deleting chirp of the restored author: %v", err)
+		}
+	})
+}
+
+func TestStorePurgeTrash(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		author := mustCreateUser(t, store, "author@example.com")
+		fan := mustCreateUser(t, store, "fan@example.com")
+		root := mustCreateChirp(t, store, NewChirp{Body: "root", AuthorID: author.ID})
+		reply := mustCreateChirp(t, store, NewChirp{Body: "reply", AuthorID: author.ID, InReplyTo: root.ID})
+		nested := mustCreateChirp(t, store, NewChirp{Body: "nested", AuthorID: author.ID, InReplyTo: reply.ID})
+		err := store.LikeChirp(reply.ID, author.ID)
+		if err != nil {
+			t.Fatalf("liking chirp: %v", err)
+		}
+		err = store.LikeChirp(root.ID, fan.ID)
+		if err != nil {
+			t.Fatalf("liking chirp: %v", err)
+		}
+		err = store.SaveRefreshToken(fan.ID, "token")
+		if err != nil {
+			t.Fatalf("saving refresh token: %v", err)
+		}
+
+		err = store.DeleteChirp(reply.ID)
+		if err != nil {
+			t.Fatalf("deleting chirp: %v", err)
+		}
+		err = store.DeleteUser(fan.ID)
+		if err != nil {
+			t.Fatalf("deleting user: %v", err)
+		}
+
+		// Nothing was deleted before the cutoff yet.
+		purged, err := store.PurgeTrash(time.Now().Add(-time.Hour))
+		if err != nil || purged != 0 {
+			t.Fatalf("purging old trash: got %d, %v, want 0", purged, err)
+		}
+		purged, err = store.PurgeTrash(time.Now().Add(time.Second))
+		if err != nil || purged != 2 {
+			t.Fatalf("purging trash: got %d, %v, want 2", purged, err)
+		}
+
+		trash, err := store.ListTrash()
+		if err != nil {
+			t.Fatalf("listing trash: %v", err)
+		}
+		if len(trash.Chirps) != 0 || len(trash.Users) != 0 {
+			t.Errorf("trash after purging: %+v", trash)
+		}
+		err = store.RestoreChirp(reply.ID)
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("restoring a purged chirp: got %v, want ErrNotExist", err)
+		}
+		_, err = store.GetUserByEmail(fan.Email)
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("getting a purged user: got %v, want ErrNotExist", err)
+		}
+		likes, err := store.GetLikes(root.ID)
+		if err != nil || len(likes) != 0 {
+			t.Errorf("likes by the purged user: got %+v, %v", likes, err)
+		}
+		_, err = store.GetLikes(reply.ID)
+		if !errors.Is(err, ErrNotExist) {
+			t.Errorf("likes of the purged chirp: got %v, want ErrNotExist", err)
+		}
+
+		// The thread is split at the purged chirp.
+		thread, err := store.GetThread(root.ID, 10)
+		if err != nil || threadShape(thread) != "1" {
+			t.Errorf("thread of the root after purging: got %s, %v", threadShape(thread), err)
+		}
+		thread, err = store.GetThread(reply.ID, 10)
+		if err != nil || threadShape(thread) != fmt.Sprintf("%d?[%d]", reply.ID, nested.ID) {
+			t.Errorf("thread of the purged chirp: got %s, %v", threadShape(thread), err)
+		}
+
+		// The snapshot of what is left is consistent.
+		dbStructure, err := store.Snapshot()
+		if err != nil {
+			t.Fatalf("taking snapshot: %v", err)
+		}
+		err = dbStructure.Validate()
+		if err != nil {
+			t.Errorf("validating snapshot: %v", err)
+		}
+	})
+}
@@ -0,0 +1,109 @@
+package database
+
+// ThreadNode is a chirp in a thread, with the replies below it in tree
+// order, oldest first at each level.
+//
+// A chirp in the trash stays in the thread as a placeholder while replies
+// below it are still visible, so they keep their place in the
+// conversation: Chirp is nil and only ID is known. Deleted chirps without
+// visible replies are left out. Purging a chirp splits the thread there,
+// as nothing records what the chirp replied to any more; its replies stay
+// reachable from its ID, which shows as a placeholder root.
+type ThreadNode struct {
+	ID    int
+	Chirp *Chirp
+	// Replies are the replies down to the depth limit; ReplyCount counts
+	// them even past it, so a client can tell where the thread goes on.
+	Replies    []ThreadNode
+	ReplyCount int
+}
+
+// threadSource gives buildThread access to a store's chirps: the chirp
+// with an ID, trashed or not, and the IDs of the replies to a chirp, in
+// ascending order.
+type threadSource struct {
+	chirp   func(id int) (Chirp, bool)
+	replies func(id int) []int
+}
+
+// buildThread returns the thread below the chirp with the given ID, down
+// to depth levels of replies. It returns ErrNotExist if the chirp is
+// deleted and has no visible replies.
+func buildThread(src threadSource, id, depth int) (ThreadNode, error) {
+	b := threadBuilder{
+		src:     src,
+		visible: map[int]bool{},
+	}
+	if !b.isVisible(id) {
+		return ThreadNode{}, ErrNotExist
+	}
+	return b.node(id, depth), nil
+}
+
+type threadBuilder struct {
+	src threadSource
+	// visible caches whether a chirp or any reply below it is visible.
+	visible map[int]bool
+}
+
+func (b *threadBuilder) isVisible(id int) bool {
+	if visible, ok := b.visible[id]; ok {
+		return visible
+	}
+	chirp, ok := b.src.chirp(id)
+	visible := ok && chirp.DeletedAt == nil
+	for _, reply := range b.src.replies(id) {
+		// Every reply is checked, not just up to the first visible one,
+		// so the cache covers the whole subtree.
+		if b.isVisible(reply) {
+			visible = true
+		}
+	}
+	b.visible[id] = visible
+	return visible
+}
+
+func (b *threadBuilder) node(id, depth int) ThreadNode {
+	node := ThreadNode{
+		ID:      id,
+		Replies: []ThreadNode{},
+	}
+	chirp, ok := b.src.chirp(id)
+	if ok && chirp.DeletedAt == nil {
+		node.Chirp = &chirp
+	}
+	for _, reply := range b.src.replies(id) {
+		if !b.isVisible(reply) {
+			continue
+		}
+		node.ReplyCount++
+		if depth > 0 {
+			node.Replies = append(node.Replies, b.node(reply, depth-1))
+		}
+	}
+	return node
+}
+
+// GetThread returns the chirp with the given ID and the replies below it,
+// down to depth levels. It returns ErrNotExist if there is no such chirp,
+// or it was deleted and has no visible replies.
+func (db *DB) GetThread(id, depth int) (ThreadNode, error) {
+	thread := ThreadNode{}
+	err := db.View(func(dbStructure DBStructure) error {
+		var err error
+		thread, err = buildThread(threadSource{
+			chirp: func(id int) (Chirp, bool) {
+				chirp, ok := dbStructure.Chirps[id]
+				return chirp, ok
+			},
+			replies: func(id int) []int {
+				return db.idx.repliesByParent[id]
+			},
+		}, id, depth)
+		return err
+	})
+	if err != nil {
+		return ThreadNode{}, err
+	}
+	return thread, nil
+}
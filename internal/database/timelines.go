@@ -0,0 +1,227 @@
+package database
+
+import (
+	"math"
+	"sort"
+)
+
+// DefaultFanoutThreshold is how many followers an account can have before
+// its chirps stop being pushed into its followers' timelines.
+const DefaultFanoutThreshold = 1000
+
+// Home timelines are materialized by fan-out on write: a new chirp's ID is
+// pushed into the timeline of its author and of each of their followers.
+// For an account with at least the fanout threshold of followers that
+// would be too many writes per chirp, so its chirps are only pushed to its
+// author and are merged into its followers' timelines when they are read.
+//
+// A user's timeline therefore holds the chirps of their own and of the
+// users they follow below the threshold. Following such a user backfills
+// their chirps, unfollowing them removes their chirps, and a user who
+// drops below the threshold has their chirps backfilled into their
+// followers' timelines. Trashed chirps stay in timelines and are skipped
+// when reading; restoring a chirp pushes it again. Timelines that got out
+// of step, for example after the threshold changed, are repaired by
+// RebuildTimelines.
+
+// TimelineEntry records that a chirp is in a user's home timeline.
+type TimelineEntry struct {
+	UserID  int `json:"user_id"`
+	ChirpID int `json:"chirp_id"`
+}
+
+// SetFanoutThreshold sets how many followers an account can have before
+// its chirps are read into its followers' timelines instead of pushed. It
+// must be called before the DB is used.
+func (db *DB) SetFanoutThreshold(threshold int) {
+	db.fanoutThreshold = threshold
+}
+
+// pushChirp adds a new or restored chirp to its author's timeline and,
+// below the threshold, to their followers'.
+func (t *tx) pushChirp(chirp Chirp, threshold int) {
+	t.putTimelineEntry(chirp.AuthorID, chirp.ID)
+	followers := t.idx.followers[chirp.AuthorID]
+	if len(followers) >= threshold {
+		return
+	}
+	for _, followerID := range followers {
+		t.putTimelineEntry(followerID, chirp.ID)
+	}
+}
+
+// follow adds a follow and backfills the followee's chirps into the
+// follower's timeline, unless the followee reached the threshold.
+func (t *tx) follow(follow Follow, threshold int) {
+	t.putFollow(follow)
+	if len(t.idx.followers[follow.FolloweeID]) < threshold {
+		t.backfillTimeline(follow.FollowerID, follow.FolloweeID)
+	}
+}
+
+// unfollow removes a follow and the followee's chirps from the follower's
+// timeline. If that takes the followee below the threshold, their chirps
+// are backfilled into their remaining followers' timelines.
+func (t *tx) unfollow(followerID, followeeID, threshold int) {
+	if _, ok := t.data.Follows[followerID][followeeID]; !ok {
+		return
+	}
+	crossing := len(t.idx.followers[followeeID]) == threshold
+	t.deleteFollow(followerID, followeeID)
+	stale := []int{}
+	for _, chirpID := range t.data.Timelines[followerID] {
+		if t.data.Chirps[chirpID].AuthorID == followeeID {
+			stale = append(stale, chirpID)
+		}
+	}
+	for _, chirpID := range stale {
+		t.deleteTimelineEntry(followerID, chirpID)
+	}
+	if crossing {
+		for _, otherID := range t.idx.followers[followeeID] {
+			t.backfillTimeline(otherID, followeeID)
+		}
+	}
+}
+
+// backfillTimeline adds the author's chirps that are not in the trash to
+// the user's timeline.
+func (t *tx) backfillTimeline(userID, authorID int) {
+	for _, chirpID := range t.idx.chirpsByAuthor[authorID] {
+		t.putTimelineEntry(userID, chirpID)
+	}
+}
+
+// GetTimeline merges the user's materialized timeline with the chirps of
+// the users they follow at or above the threshold, walking both down from
+// afterID until the page is full.
+func (db *DB) GetTimeline(userID, afterID, limit int) (ChirpPage, error) {
+	page := ChirpPage{
+		Chirps: []Chirp{},
+	}
+	err := db.View(func(dbStructure DBStructure) error {
+		highFanout := []int{}
+		for followeeID := range dbStructure.Follows[userID] {
+			if len(db.idx.followers[followeeID]) >= db.fanoutThreshold {
+				highFanout = append(highFanout, followeeID)
+			}
+		}
+		pushed := dbStructure.Timelines[userID]
+		pulled := []int{}
+		if len(highFanout) > 0 {
+			pulled = db.idx.chirpsByAuthors(highFanout)
+		}
+
+		before := afterID
+		if before == 0 {
+			before = math.MaxInt
+		}
+		i := sort.SearchInts(pushed, before) - 1
+		j := sort.SearchInts(pulled, before) - 1
+		for i >= 0 || j >= 0 {
+			var id int
+			switch {
+			case j < 0 || i >= 0 && pushed[i] > pulled[j]:
+				id = pushed[i]
+				i--
+			case i < 0 || pulled[j] > pushed[i]:
+				id = pulled[j]
+				j--
+			default:
+				id = pushed[i]
+				i--
+				j--
+			}
+			chirp := dbStructure.Chirps[id]
+			if chirp.DeletedAt != nil {
+				continue
+			}
+			if limit > 0 && len(page.Chirps) == limit {
+				page.More = true
+				break
+			}
+			page.Chirps = append(page.Chirps, chirp)
+		}
+		return nil
+	})
+	if err != nil {
+		return ChirpPage{}, err
+	}
+	return page, nil
+}
+
+// RebuildTimelines recomputes every home timeline from the follows and
+// chirps at the current threshold, writing only the entries that change,
+// and returns how many entries the timelines hold.
+func (db *DB) RebuildTimelines() (int, error) {
+	total := 0
+	err := db.update(func(t *tx) error {
+		timelines := buildTimelines(t.data, db.fanoutThreshold)
+		stale := []TimelineEntry{}
+		for userID, ids := range t.data.Timelines {
+			want := timelines[userID]
+			for _, chirpID := range ids {
+				i := sort.SearchInts(want, chirpID)
+				if i == len(want) || want[i] != chirpID {
+					stale = append(stale, TimelineEntry{UserID: userID, ChirpID: chirpID})
+				}
+			}
+		}
+		for _, entry := range stale {
+			t.deleteTimelineEntry(entry.UserID, entry.ChirpID)
+		}
+		for userID, ids := range timelines {
+			for _, chirpID := range ids {
+				t.putTimelineEntry(userID, chirpID)
+			}
+			total += len(ids)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+// buildTimelines computes the home timelines of dbStructure from scratch:
+// each chirp that is not in the trash goes to its author and, if they have
+// fewer followers than threshold, to their followers.
+func buildTimelines(dbStructure *DBStructure, threshold int) map[int][]int {
+	followers := map[int][]int{}
+	for followerID, following := range dbStructure.Follows {
+		for followeeID := range following {
+			followers[followeeID] = append(followers[followeeID], followerID)
+		}
+	}
+
+	timelines := map[int][]int{}
+	for id, chirp := range dbStructure.Chirps {
+		if chirp.DeletedAt != nil {
+			continue
+		}
+		timelines[chirp.AuthorID] = append(timelines[chirp.AuthorID], id)
+		if len(followers[chirp.AuthorID]) >= threshold {
+			continue
+		}
+		for _, followerID := range followers[chirp.AuthorID] {
+			timelines[followerID] = append(timelines[followerID], id)
+		}
+	}
+	for _, ids := range timelines {
+		sort.Ints(ids)
+	}
+	return timelines
+}
+
+// materializeTimelines builds the home timelines of a structure from
+// before they existed, at the default threshold, and returns how many
+// entries it added.
+func (dbStructure *DBStructure) materializeTimelines() int {
+	dbStructure.Timelines = buildTimelines(dbStructure, DefaultFanoutThreshold)
+	added := 0
+	for _, ids := range dbStructure.Timelines {
+		added += len(ids)
+	}
+	return added
+}
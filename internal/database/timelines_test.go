@@ -0,0 +1,172 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+)
+
+// materializedTimelines returns the store's materialized home timelines,
+// leaving out empty ones.
+func materializedTimelines(t *testing.T, store Store) map[int][]int {
+	t.Helper()
+	snapshot, err := store.Snapshot()
+	if err != nil {
+		t.Fatalf("taking snapshot: %v", err)
+	}
+	timelines := map[int][]int{}
+	for userID, ids := range snapshot.Timelines {
+		if len(ids) > 0 {
+			timelines[userID] = ids
+		}
+	}
+	return timelines
+}
+
+// checkTimeline checks the chirp IDs of a page of a user's home timeline.
+func checkTimeline(t *testing.T, store Store, userID, afterID, limit int, want []int, wantMore bool) {
+	t.Helper()
+	page, err := store.GetTimeline(userID, afterID, limit)
+	if err != nil {
+		t.Fatalf("getting timeline of user %d: %v", userID, err)
+	}
+	if ids := chirpIDs(page.Chirps); !reflect.DeepEqual(ids, want) || page.More != wantMore {
+		t.Errorf("timeline of user %d after %d: got %v, more %v, want %v, more %v", userID, afterID, ids, page.More, want, wantMore)
+	}
+}
+
+// TestTimelinesAroundFanoutThreshold follows, posts and unfollows around a
+// fanout threshold of two followers, and checks what is pushed into the
+// materialized timelines, what GetTimeline merges in when reading, and
+// the backfill when an unfollow takes an account below the threshold.
+func TestTimelinesAroundFanoutThreshold(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		store.SetFanoutThreshold(2)
+		celeb := mustCreateUser(t, store, "celeb@example.com")
+		fan := mustCreateUser(t, store, "fan@example.com")
+		other := mustCreateUser(t, store, "other@example.com")
+		friend := mustCreateUser(t, store, "friend@example.com")
+		follow := func(followerID, followeeID int) {
+			t.Helper()
+			err := store.FollowUser(followerID, followeeID)
+			if err != nil {
+				t.Fatalf("user %d following %d: %v", followerID, followeeID, err)
+			}
+		}
+		unfollow := func(followerID, followeeID int) {
+			t.Helper()
+			err := store.UnfollowUser(followerID, followeeID)
+			if err != nil {
+				t.Fatalf("user %d unfollowing %d: %v", followerID, followeeID, err)
+			}
+		}
+
+		early := mustCreateChirp(t, store, NewChirp{Body: "early", AuthorID: celeb.ID})
+		// The first follow is below the threshold and backfills; the second
+		// reaches it and doesn't.
+		follow(fan.ID, celeb.ID)
+		follow(other.ID, celeb.ID)
+		late := mustCreateChirp(t, store, NewChirp{Body: "late", AuthorID: celeb.ID})
+		own := mustCreateChirp(t, store, NewChirp{Body: "own", AuthorID: fan.ID})
+		friends := mustCreateChirp(t, store, NewChirp{Body: "friend's", AuthorID: friend.ID})
+		follow(fan.ID, friend.ID)
+
+		want := map[int][]int{
+			celeb.ID:  {early.ID, late.ID},
+			fan.ID:    {early.ID, own.ID, friends.ID},
+			friend.ID: {friends.ID},
+		}
+		if got := materializedTimelines(t, store); !reflect.DeepEqual(got, want) {
+			t.Errorf("got timelines %v, want %v", got, want)
+		}
+
+		// Reading merges in the chirps of accounts at the threshold, once
+		// each, across pages.
+		checkTimeline(t, store, fan.ID, 0, 0, []int{friends.ID, own.ID, late.ID, early.ID}, false)
+		checkTimeline(t, store, fan.ID, 0, 2, []int{friends.ID, own.ID}, true)
+		checkTimeline(t, store, fan.ID, own.ID, 2, []int{late.ID, early.ID}, false)
+		checkTimeline(t, store, other.ID, 0, 0, []int{late.ID, early.ID}, false)
+
+		// Unfollowing takes the celeb below the threshold, so their chirps
+		// are backfilled into the remaining follower's timeline.
+		unfollow(other.ID, celeb.ID)
+		// Unfollowing an account below the threshold removes its chirps.
+		unfollow(fan.ID, friend.ID)
+		want = map[int][]int{
+			celeb.ID:  {early.ID, late.ID},
+			fan.ID:    {early.ID, late.ID, own.ID},
+			friend.ID: {friends.ID},
+		}
+		if got := materializedTimelines(t, store); !reflect.DeepEqual(got, want) {
+			t.Errorf("after unfollowing: got timelines %v, want %v", got, want)
+		}
+		checkTimeline(t, store, fan.ID, 0, 0, []int{own.ID, late.ID, early.ID}, false)
+		checkTimeline(t, store, other.ID, 0, 0, []int{}, false)
+
+		// Chirps posted below the threshold are pushed again.
+		after := mustCreateChirp(t, store, NewChirp{Body: "after", AuthorID: celeb.ID})
+		checkTimeline(t, store, fan.ID, 0, 1, []int{after.ID}, true)
+		if got := materializedTimelines(t, store)[fan.ID]; !reflect.DeepEqual(got, []int{early.ID, late.ID, own.ID, after.ID}) {
+			t.Errorf("fan's timeline after a new chirp: got %v", got)
+		}
+	})
+}
+
+// TestRebuildTimelines checks that RebuildTimelines brings timelines that
+// got out of step with the threshold back in line with it, and reports
+// how many entries they hold.
+func TestRebuildTimelines(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		store.SetFanoutThreshold(1)
+		celeb := mustCreateUser(t, store, "celeb@example.com")
+		fan := mustCreateUser(t, store, "fan@example.com")
+		err := store.FollowUser(fan.ID, celeb.ID)
+		if err != nil {
+			t.Fatalf("following: %v", err)
+		}
+		first := mustCreateChirp(t, store, NewChirp{Body: "first", AuthorID: celeb.ID})
+		second := mustCreateChirp(t, store, NewChirp{Body: "second", AuthorID: celeb.ID})
+		trashed := mustCreateChirp(t, store, NewChirp{Body: "trashed", AuthorID: fan.ID})
+		err = store.DeleteChirp(trashed.ID)
+		if err != nil {
+			t.Fatalf("deleting chirp: %v", err)
+		}
+
+		// At a threshold of one the celeb's chirps were only pushed to
+		// them; raising it leaves the fan's timeline missing them.
+		store.SetFanoutThreshold(DefaultFanoutThreshold)
+		want := map[int][]int{
+			celeb.ID: {first.ID, second.ID},
+			fan.ID:   {trashed.ID},
+		}
+		if got := materializedTimelines(t, store); !reflect.DeepEqual(got, want) {
+			t.Fatalf("before rebuilding: got timelines %v, want %v", got, want)
+		}
+		checkTimeline(t, store, fan.ID, 0, 0, []int{}, false)
+
+		// Rebuilding pushes them, and drops the trashed chirp.
+		total, err := store.RebuildTimelines()
+		if err != nil {
+			t.Fatalf("rebuilding timelines: %v", err)
+		}
+		if total != 4 {
+			t.Errorf("rebuilt timelines hold %d entries, want 4", total)
+		}
+		want = map[int][]int{
+			celeb.ID: {first.ID, second.ID},
+			fan.ID:   {first.ID, second.ID},
+		}
+		if got := materializedTimelines(t, store); !reflect.DeepEqual(got, want) {
+			t.Errorf("after rebuilding: got timelines %v, want %v", got, want)
+		}
+		checkTimeline(t, store, fan.ID, 0, 0, []int{second.ID, first.ID}, false)
+
+		// Rebuilding timelines that are in step changes nothing.
+		total, err = store.RebuildTimelines()
+		if err != nil || total != 4 {
+			t.Errorf("rebuilding again: got %d, %v, want 4", total, err)
+		}
+		if got := materializedTimelines(t, store); !reflect.DeepEqual(got, want) {
+			t.Errorf("after rebuilding again: got timelines %v, want %v", got, want)
+		}
+	})
+}
@@ -0,0 +1,155 @@
+package database
+
+import (
+	"sort"
+	"time"
+)
+
+// Trash holds the chirps and users that have been deleted but not yet
+// purged, in ascending ID order.
+type Trash struct {
+	Chirps []Chirp `json:"chirps"`
+	Users  []User  `json:"users"`
+}
+
+func (db *DB) ListTrash() (Trash, error) {
+	trash := Trash{
+		Chirps: []Chirp{},
+		Users:  []User{},
+	}
+	err := db.View(func(dbStructure DBStructure) error {
+		for _, chirp := range dbStructure.Chirps {
+			if chirp.DeletedAt != nil {
+				trash.Chirps = append(trash.Chirps, chirp)
+			}
+		}
+		for _, user := range dbStructure.Users {
+			if user.DeletedAt != nil {
+				trash.Users = append(trash.Users, user)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return Trash{}, err
+	}
+
+	sort.Slice(trash.Chirps, func(i, j int) bool {
+		return trash.Chirps[i].ID < trash.Chirps[j].ID
+	})
+	sort.Slice(trash.Users, func(i, j int) bool {
+		return trash.Users[i].ID < trash.Users[j].ID
+	})
+	return trash, nil
+}
+
+// RestoreChirp takes a chirp out of the trash. It returns ErrNotExist if
+// the chirp isn't in the trash.
+func (db *DB) RestoreChirp(id int) error {
+	return db.update(func(t *tx) error {
+		chirp, ok := t.data.Chirps[id]
+		if !ok || chirp.DeletedAt == nil {
+			return ErrNotExist
+		}
+		chirp.DeletedAt = nil
+		t.putChirp(chirp)
+		t.pushChirp(chirp, db.fanoutThreshold)
+		return nil
+	})
+}
+
+// RestoreUser takes a user out of the trash. It returns ErrNotExist if the
+// user isn't in the trash.
+func (db *DB) RestoreUser(id int) error {
+	return db.update(func(t *tx) error {
+		user, ok := t.data.Users[id]
+		if !ok || user.DeletedAt == nil {
+			return ErrNotExist
+		}
+		user.DeletedAt = nil
+		t.putUser(user)
+		return nil
+	})
+}
+
+// PurgeTrash permanently removes the chirps and users that were deleted
+// before cutoff, along with the purged chirps' revisions, the purged
+// users' refresh tokens, follows and timelines, and the likes, timeline
+// entries and notifications of both, and returns how many chirps and users it
+// removed.
+func (db *DB) PurgeTrash(cutoff time.Time) (int, error) {
+	purged := 0
+	err := db.update(func(t *tx) error {
+		chirps := map[int]bool{}
+		for id, chirp := range t.data.Chirps {
+			if chirp.DeletedAt != nil && chirp.DeletedAt.Before(cutoff) {
+				t.deleteChirp(id)
+				t.deleteChirpRevisions(id)
+				for userID := range t.data.Likes[id] {
+					t.deleteLike(id, userID)
+				}
+				chirps[id] = true
+				purged++
+			}
+		}
+		stale := []TimelineEntry{}
+		for userID, chirpIDs := range t.data.Timelines {
+			for _, chirpID := range chirpIDs {
+				if chirps[chirpID] {
+					stale = append(stale, TimelineEntry{UserID: userID, ChirpID: chirpID})
+				}
+			}
+		}
+		for _, entry := range stale {
+			t.deleteTimelineEntry(entry.UserID, entry.ChirpID)
+		}
+		for userID, notifications := range t.data.Notifications {
+			for chirpID := range notifications {
+				if chirps[chirpID] {
+					t.deleteNotification(userID, chirpID)
+				}
+			}
+		}
+
+		users := map[int]bool{}
+		for id, user := range t.data.Users {
+			if user.DeletedAt != nil && user.DeletedAt.Before(cutoff) {
+				t.deleteUser(id)
+				users[id] = true
+				purged++
+			}
+		}
+		for token, refreshToken := range t.data.RefreshTokens {
+			if users[refreshToken.UserID] {
+				t.deleteRefreshToken(token)
+			}
+		}
+		for chirpID, likes := range t.data.Likes {
+			for userID := range likes {
+				if users[userID] {
+					t.deleteLike(chirpID, userID)
+				}
+			}
+		}
+		for followerID, following := range t.data.Follows {
+			for followeeID := range following {
+				if users[followerID] || users[followeeID] {
+					t.unfollow(followerID, followeeID, db.fanoutThreshold)
+				}
+			}
+		}
+		for userID := range users {
+			for _, chirpID := range append([]int(nil), t.data.Timelines[userID]...) {
+				t.deleteTimelineEntry(userID, chirpID)
+			}
+			for chirpID := range t.data.Notifications[userID] {
+				t.deleteNotification(userID, chirpID)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return purged, nil
+}
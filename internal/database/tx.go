@@ -0,0 +1,454 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// tx is the write side of a mutation made through DB.update. Every change
+// goes through one of its put/delete methods, which update the structure
+// and the secondary indexes and record a WAL entry and a feed event for
+// the change.
+type tx struct {
+	data *DBStructure
+	idx  *indexes
+	// entries collects the WAL entries for the changes made so far. It is
+	// nil while replaying the WAL, when nothing must be recorded.
+	entries *[]walEntry
+	// events collects the feed events for the changes made so far, to be
+	// published once the mutation succeeds. Like entries, it is nil while
+	// replaying.
+	events *[]Event
+	// undo collects the changes that revert each change made so far, to be
+	// applied in reverse if the mutation fails. It is nil while replaying
+	// and while undoing.
+	undo *[]func(*tx)
+}
+
+// walEntry is one line of the write-ahead log. Entries hold the full new
+// value of what they touch, so replaying an entry that is already part of
+// the snapshot is harmless.
+type walEntry struct {
+	Op     string          `json:"op"`
+	Entity string          `json:"entity"`
+	Key    string          `json:"key"`
+	Value  json.RawMessage `json:"value,omitempty"`
+}
+
+const (
+	walOpPut    = "put"
+	walOpDelete = "delete"
+	// walOpSnapshot marks the point in the log where the state matches the
+	// snapshot file whose checksum is the entry's key; see wal.go.
+	walOpSnapshot = "snapshot"
+
+	entityChirp          = "chirp"
+	entityUser           = "user"
+	entityRefreshToken   = "refresh_token"
+	entityChirpRevisions = "chirp_revisions"
+	entityLike           = "like"
+	entityFollow         = "follow"
+	entityTimelineEntry  = "timeline_entry"
+	entityNotification   = "notification"
+)
+
+func (t *tx) record(op, entity, key string, value any) {
+	if t.entries == nil {
+		return
+	}
+	entry := walEntry{
+		Op:     op,
+		Entity: entity,
+		Key:    key,
+	}
+	if value != nil {
+		// The values are plain structs; marshalling them can't fail.
+		entry.Value, _ = json.Marshal(value)
+	}
+	*t.entries = append(*t.entries, entry)
+}
+
+// emit records a feed event for a change to a record that was visible
+// before the change or is visible after it.
+func (t *tx) emit(before, after bool, event Event) {
+	if t.events == nil {
+		return
+	}
+	kind, ok := changeKind(before, after)
+	if !ok {
+		return
+	}
+	event.Kind = kind
+	*t.events = append(*t.events, event)
+}
+
+// onUndo records how to revert a change.
+func (t *tx) onUndo(fn func(*tx)) {
+	if t.undo == nil {
+		return
+	}
+	*t.undo = append(*t.undo, fn)
+}
+
+// rollback reverts the changes recorded in undo, newest first, without
+// recording WAL entries or events for them.
+func rollback(data *DBStructure, idx *indexes, undo []func(*tx)) {
+	r := &tx{data: data, idx: idx}
+	for i := len(undo) - 1; i >= 0; i-- {
+		undo[i](r)
+	}
+}
+
+func (t *tx) putChirp(chirp Chirp) {
+	old, ok := t.data.Chirps[chirp.ID]
+	t.onUndo(func(r *tx) {
+		if ok {
+			r.putChirp(old)
+		} else {
+			r.deleteChirp(chirp.ID)
+		}
+	})
+	if ok {
+		t.idx.removeChirp(old)
+	}
+	t.data.Chirps[chirp.ID] = chirp
+	t.data.Sequences.Chirps = max(t.data.Sequences.Chirps, chirp.ID)
+	t.idx.addChirp(chirp)
+	t.record(walOpPut, entityChirp, strconv.Itoa(chirp.ID), chirp)
+	t.emit(ok && old.DeletedAt == nil, chirp.DeletedAt == nil, Event{Chirp: &chirp})
+}
+
+func (t *tx) deleteChirp(id int) {
+	old, ok := t.data.Chirps[id]
+	if !ok {
+		return
+	}
+	t.onUndo(func(r *tx) { r.putChirp(old) })
+	delete(t.data.Chirps, id)
+	t.idx.removeChirp(old)
+	t.record(walOpDelete, entityChirp, strconv.Itoa(id), nil)
+	t.emit(old.DeletedAt == nil, false, Event{Chirp: &old})
+}
+
+func (t *tx) putUser(user User) {
+	old, ok := t.data.Users[user.ID]
+	t.onUndo(func(r *tx) {
+		if ok {
+			r.putUser(old)
+		} else {
+			r.deleteUser(user.ID)
+		}
+	})
+	if ok {
+		t.idx.removeUser(old)
+	}
+	t.data.Users[user.ID] = user
+	t.data.Sequences.Users = max(t.data.Sequences.Users, user.ID)
+	t.idx.addUser(user)
+	t.record(walOpPut, entityUser, strconv.Itoa(user.ID), user)
+	t.emit(ok && old.DeletedAt == nil, user.DeletedAt == nil, Event{User: &user})
+}
+
+func (t *tx) deleteUser(id int) {
+	old, ok := t.data.Users[id]
+	if !ok {
+		return
+	}
+	t.onUndo(func(r *tx) { r.putUser(old) })
+	delete(t.data.Users, id)
+	t.idx.removeUser(old)
+	t.record(walOpDelete, entityUser, strconv.Itoa(id), nil)
+	t.emit(old.DeletedAt == nil, false, Event{User: &old})
+}
+
+func (t *tx) putRefreshToken(refreshToken RefreshToken) {
+	old, ok := t.data.RefreshTokens[refreshToken.Token]
+	t.onUndo(func(r *tx) {
+		if ok {
+			r.putRefreshToken(old)
+		} else {
+			r.deleteRefreshToken(refreshToken.Token)
+		}
+	})
+	t.data.RefreshTokens[refreshToken.Token] = refreshToken
+	t.record(walOpPut, entityRefreshToken, refreshToken.Token, refreshToken)
+}
+
+func (t *tx) deleteRefreshToken(token string) {
+	_, ok := t.data.RefreshTokens[token]
+	if !ok {
+		return
+	}
+	old := t.data.RefreshTokens[token]
+	t.onUndo(func(r *tx) { r.putRefreshToken(old) })
+	delete(t.data.RefreshTokens, token)
+	t.record(walOpDelete, entityRefreshToken, token, nil)
+}
+
+// putChirpRevisions replaces the revision history of a chirp.
+func (t *tx) putChirpRevisions(chirpID int, revisions []ChirpRevision) {
+	old, ok := t.data.ChirpRevisions[chirpID]
+	t.onUndo(func(r *tx) {
+		if ok {
+			r.putChirpRevisions(chirpID, old)
+		} else {
+			r.deleteChirpRevisions(chirpID)
+		}
+	})
+	t.data.ChirpRevisions[chirpID] = revisions
+	t.record(walOpPut, entityChirpRevisions, strconv.Itoa(chirpID), revisions)
+}
+
+func (t *tx) deleteChirpRevisions(chirpID int) {
+	_, ok := t.data.ChirpRevisions[chirpID]
+	if !ok {
+		return
+	}
+	old := t.data.ChirpRevisions[chirpID]
+	t.onUndo(func(r *tx) { r.putChirpRevisions(chirpID, old) })
+	delete(t.data.ChirpRevisions, chirpID)
+	t.record(walOpDelete, entityChirpRevisions, strconv.Itoa(chirpID), nil)
+}
+
+func (t *tx) putLike(like Like) {
+	old, ok := t.data.Likes[like.ChirpID][like.UserID]
+	t.onUndo(func(r *tx) {
+		if ok {
+			r.putLike(Like{ChirpID: like.ChirpID, UserID: like.UserID, CreatedAt: old})
+		} else {
+			r.deleteLike(like.ChirpID, like.UserID)
+		}
+	})
+	likes, ok := t.data.Likes[like.ChirpID]
+	if !ok {
+		likes = map[int]time.Time{}
+		t.data.Likes[like.ChirpID] = likes
+	}
+	likes[like.UserID] = like.CreatedAt
+	t.record(walOpPut, entityLike, pairKey(like.ChirpID, like.UserID), like)
+}
+
+func (t *tx) deleteLike(chirpID, userID int) {
+	_, ok := t.data.Likes[chirpID][userID]
+	if !ok {
+		return
+	}
+	old := t.data.Likes[chirpID][userID]
+	t.onUndo(func(r *tx) { r.putLike(Like{ChirpID: chirpID, UserID: userID, CreatedAt: old}) })
+	delete(t.data.Likes[chirpID], userID)
+	if len(t.data.Likes[chirpID]) == 0 {
+		delete(t.data.Likes, chirpID)
+	}
+	t.record(walOpDelete, entityLike, pairKey(chirpID, userID), nil)
+}
+
+func (t *tx) putFollow(follow Follow) {
+	old, ok := t.data.Follows[follow.FollowerID][follow.FolloweeID]
+	t.onUndo(func(r *tx) {
+		if ok {
+			r.putFollow(Follow{FollowerID: follow.FollowerID, FolloweeID: follow.FolloweeID, CreatedAt: old})
+		} else {
+			r.deleteFollow(follow.FollowerID, follow.FolloweeID)
+		}
+	})
+	following, ok := t.data.Follows[follow.FollowerID]
+	if !ok {
+		following = map[int]time.Time{}
+		t.data.Follows[follow.FollowerID] = following
+	}
+	following[follow.FolloweeID] = follow.CreatedAt
+	t.idx.addFollow(follow.FollowerID, follow.FolloweeID)
+	t.record(walOpPut, entityFollow, pairKey(follow.FollowerID, follow.FolloweeID), follow)
+}
+
+func (t *tx) deleteFollow(followerID, followeeID int) {
+	_, ok := t.data.Follows[followerID][followeeID]
+	if !ok {
+		return
+	}
+	old := t.data.Follows[followerID][followeeID]
+	t.onUndo(func(r *tx) { r.putFollow(Follow{FollowerID: followerID, FolloweeID: followeeID, CreatedAt: old}) })
+	delete(t.data.Follows[followerID], followeeID)
+	if len(t.data.Follows[followerID]) == 0 {
+		delete(t.data.Follows, followerID)
+	}
+	t.idx.removeFollow(followerID, followeeID)
+	t.record(walOpDelete, entityFollow, pairKey(followerID, followeeID), nil)
+}
+
+// putTimelineEntry adds a chirp to a user's timeline. Adding one that is
+// already there records nothing.
+func (t *tx) putTimelineEntry(userID, chirpID int) {
+	ids := t.data.Timelines[userID]
+	i := sort.SearchInts(ids, chirpID)
+	if i < len(ids) && ids[i] == chirpID {
+		return
+	}
+	t.onUndo(func(r *tx) { r.deleteTimelineEntry(userID, chirpID) })
+	t.data.Timelines[userID] = insertID(ids, chirpID)
+	t.record(walOpPut, entityTimelineEntry, pairKey(userID, chirpID), TimelineEntry{UserID: userID, ChirpID: chirpID})
+}
+
+func (t *tx) deleteTimelineEntry(userID, chirpID int) {
+	ids := t.data.Timelines[userID]
+	i := sort.SearchInts(ids, chirpID)
+	if i == len(ids) || ids[i] != chirpID {
+		return
+	}
+	t.onUndo(func(r *tx) { r.putTimelineEntry(userID, chirpID) })
+	ids = removeID(ids, chirpID)
+	if len(ids) == 0 {
+		delete(t.data.Timelines, userID)
+	} else {
+		t.data.Timelines[userID] = ids
+	}
+	t.record(walOpDelete, entityTimelineEntry, pairKey(userID, chirpID), nil)
+}
+
+func (t *tx) putNotification(notification Notification) {
+	old, ok := t.data.Notifications[notification.UserID][notification.ChirpID]
+	t.onUndo(func(r *tx) {
+		if ok {
+			r.putNotification(old)
+		} else {
+			r.deleteNotification(notification.UserID, notification.ChirpID)
+		}
+	})
+	notifications, ok := t.data.Notifications[notification.UserID]
+	if !ok {
+		notifications = map[int]Notification{}
+		t.data.Notifications[notification.UserID] = notifications
+	}
+	notifications[notification.ChirpID] = notification
+	t.record(walOpPut, entityNotification, pairKey(notification.UserID, notification.ChirpID), notification)
+}
+
+func (t *tx) deleteNotification(userID, chirpID int) {
+	_, ok := t.data.Notifications[userID][chirpID]
+	if !ok {
+		return
+	}
+	old := t.data.Notifications[userID][chirpID]
+	t.onUndo(func(r *tx) { r.putNotification(old) })
+	delete(t.data.Notifications[userID], chirpID)
+	if len(t.data.Notifications[userID]) == 0 {
+		delete(t.data.Notifications, userID)
+	}
+	t.record(walOpDelete, entityNotification, pairKey(userID, chirpID), nil)
+}
+
+// apply replays a WAL entry. t must not be recording.
+func (t *tx) apply(entry walEntry) error {
+	switch {
+	case entry.Entity == entityChirp && entry.Op == walOpPut:
+		chirp := Chirp{}
+		err := json.Unmarshal(entry.Value, &chirp)
+		if err != nil {
+			return err
+		}
+		t.putChirp(chirp)
+	case entry.Entity == entityChirp && entry.Op == walOpDelete:
+		id, err := strconv.Atoi(entry.Key)
+		if err != nil {
+			return err
+		}
+		t.deleteChirp(id)
+	case entry.Entity == entityUser && entry.Op == walOpPut:
+		user := User{}
+		err := json.Unmarshal(entry.Value, &user)
+		if err != nil {
+			return err
+		}
+		t.putUser(user)
+	case entry.Entity == entityUser && entry.Op == walOpDelete:
+		id, err := strconv.Atoi(entry.Key)
+		if err != nil {
+			return err
+		}
+		t.deleteUser(id)
+	case entry.Entity == entityRefreshToken && entry.Op == walOpPut:
+		refreshToken := RefreshToken{}
+		err := json.Unmarshal(entry.Value, &refreshToken)
+		if err != nil {
+			return err
+		}
+		t.putRefreshToken(refreshToken)
+	case entry.Entity == entityRefreshToken && entry.Op == walOpDelete:
+		t.deleteRefreshToken(entry.Key)
+	case entry.Entity == entityChirpRevisions && entry.Op == walOpPut:
+		chirpID, err := strconv.Atoi(entry.Key)
+		if err != nil {
+			return err
+		}
+		revisions := []ChirpRevision{}
+		err = json.Unmarshal(entry.Value, &revisions)
+		if err != nil {
+			return err
+		}
+		t.putChirpRevisions(chirpID, revisions)
+	case entry.Entity == entityChirpRevisions && entry.Op == walOpDelete:
+		chirpID, err := strconv.Atoi(entry.Key)
+		if err != nil {
+			return err
+		}
+		t.deleteChirpRevisions(chirpID)
+	case entry.Entity == entityLike && entry.Op == walOpPut:
+		like := Like{}
+		err := json.Unmarshal(entry.Value, &like)
+		if err != nil {
+			return err
+		}
+		t.putLike(like)
+	case entry.Entity == entityLike && entry.Op == walOpDelete:
+		chirpID, userID, err := parsePairKey(entry.Key)
+		if err != nil {
+			return err
+		}
+		t.deleteLike(chirpID, userID)
+	case entry.Entity == entityFollow && entry.Op == walOpPut:
+		follow := Follow{}
+		err := json.Unmarshal(entry.Value, &follow)
+		if err != nil {
+			return err
+		}
+		t.putFollow(follow)
+	case entry.Entity == entityFollow && entry.Op == walOpDelete:
+		followerID, followeeID, err := parsePairKey(entry.Key)
+		if err != nil {
+			return err
+		}
+		t.deleteFollow(followerID, followeeID)
+	case entry.Entity == entityTimelineEntry && entry.Op == walOpPut:
+		timelineEntry := TimelineEntry{}
+		err := json.Unmarshal(entry.Value, &timelineEntry)
+		if err != nil {
+			return err
+		}
+		t.putTimelineEntry(timelineEntry.UserID, timelineEntry.ChirpID)
+	case entry.Entity == entityTimelineEntry && entry.Op == walOpDelete:
+		userID, chirpID, err := parsePairKey(entry.Key)
+		if err != nil {
+			return err
+		}
+		t.deleteTimelineEntry(userID, chirpID)
+	case entry.Entity == entityNotification && entry.Op == walOpPut:
+		notification := Notification{}
+		err := json.Unmarshal(entry.Value, &notification)
+		if err != nil {
+			return err
+		}
+		t.putNotification(notification)
+	case entry.Entity == entityNotification && entry.Op == walOpDelete:
+		userID, chirpID, err := parsePairKey(entry.Key)
+		if err != nil {
+			return err
+		}
+		t.deleteNotification(userID, chirpID)
+	default:
+		return fmt.Errorf("unknown WAL entry %s %s", entry.Op, entry.Entity)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNewerSchema is returned when a file was written by a newer version of
+// the program, whose schema this one doesn't know.
+var ErrNewerSchema = errors.New("database was written by a newer schema version")
+
+// schemaUpgrade brings a DBStructure from version-1 to version. apply
+// returns how many records or counters it changed.
+type schemaUpgrade struct {
+	version     int
+	description string
+	apply       func(dbStructure *DBStructure) int
+}
+
+// schemaUpgrades are the upgrades for the JSON file format, in order. Add
+// new ones at the end; a structure is at the version of the last upgrade
+// applied to it.
+var schemaUpgrades = []schemaUpgrade{
+	{
+		version:     1,
+		description: "start the ID sequences at the highest ID in use",
+		apply:       (*DBStructure).migrateSequences,
+	},
+	{
+		version:     2,
+		description: "backfill created_at and updated_at with the Unix epoch",
+		apply:       (*DBStructure).backfillTimestamps,
+	},
+	{
+		version:     3,
+		description: "add the chirp revision history",
+		apply:       formatOnly,
+	},
+	{
+		version:     4,
+		description: "add replies to chirps",
+		apply:       formatOnly,
+	},
+	{
+		version:     5,
+		description: "add likes",
+		apply:       formatOnly,
+	},
+	{
+		version:     6,
+		description: "add rechirps and quote chirps",
+		apply:       formatOnly,
+	},
+	{
+		version:     7,
+		description: "add follows",
+		apply:       formatOnly,
+	},
+	{
+		version:     8,
+		description: "materialize home timelines",
+		apply:       (*DBStructure).materializeTimelines,
+	},
+	{
+		version:     9,
+		description: "add mentions and mention notifications",
+		apply:       formatOnly,
+	},
+}
+
+// formatOnly applies an upgrade that only adds to the format, whose new
+// parts start out empty or zero. Its version keeps older programs, which
+// would drop the new parts, from opening the file.
+func formatOnly(*DBStructure) int {
+	return 0
+}
+
+// currentSchemaVersion is the version new structures are created at.
+var currentSchemaVersion = schemaUpgrades[len(schemaUpgrades)-1].version
+
+// UpgradeStep is an upgrade that was applied to a structure, or would be.
+type UpgradeStep struct {
+	Version     int    `json:"version"`
+	Description string `json:"description"`
+	// Changes is how many records or counters the upgrade changed.
+	Changes int `json:"changes"`
+}
+
+// UpgradeReport describes the upgrades applied to a structure.
+type UpgradeReport struct {
+	FromVersion int           `json:"from_version"`
+	ToVersion   int           `json:"to_version"`
+	Steps       []UpgradeStep `json:"steps"`
+}
+
+// upgrade applies the upgrades the structure is missing, in order. Files
+// written before versioning load at version 0 and get every upgrade.
+func (dbStructure *DBStructure) upgrade() (UpgradeReport, error) {
+	report := UpgradeReport{
+		FromVersion: dbStructure.SchemaVersion,
+		ToVersion:   dbStructure.SchemaVersion,
+		Steps:       []UpgradeStep{},
+	}
+	if dbStructure.SchemaVersion > currentSchemaVersion {
+		return report, fmt.Errorf("%w: version %d, this program supports up to %d", ErrNewerSchema, dbStructure.SchemaVersion, currentSchemaVersion)
+	}
+
+	for _, u := range schemaUpgrades {
+		if u.version <= dbStructure.SchemaVersion {
+			continue
+		}
+		changes := u.apply(dbStructure)
+		dbStructure.SchemaVersion = u.version
+		report.ToVersion = u.version
+		report.Steps = append(report.Steps, UpgradeStep{
+			Version:     u.version,
+			Description: u.description,
+			Changes:     changes,
+		})
+	}
+	return report, nil
+}
+
+// backfilledAt is the time given to chirps and users written before they
+// had timestamps. Their real creation time is unknown; the Unix epoch
+// sorts them before every timestamped record and puts them outside any
+// edit window, rather than making them look freshly posted.
+var backfilledAt = time.Unix(0, 0).UTC()
+
+// backfillTimestamps gives chirps and users written before they had
+// timestamps the backfilledAt time, and returns how many records it
+// changed.
+func (dbStructure *DBStructure) backfillTimestamps() int {
+	changed := 0
+	for id, chirp := range dbStructure.Chirps {
+		if !chirp.CreatedAt.IsZero() {
+			continue
+		}
+		chirp.CreatedAt = backfilledAt
+		chirp.UpdatedAt = backfilledAt
+		dbStructure.Chirps[id] = chirp
+		changed++
+	}
+	for id, user := range dbStructure.Users {
+		if !user.CreatedAt.IsZero() {
+			continue
+		}
+		user.CreatedAt = backfilledAt
+		user.UpdatedAt = backfilledAt
+		dbStructure.Users[id] = user
+		changed++
+	}
+	return changed
+}
@@ -0,0 +1,201 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Fixtures of the same data as files written at older schema versions: two
+// users, of whom the second follows the first once follows exist, and
+// three chirps.
+const (
+	// Before versioning: no schema version, sequences or timestamps.
+	schemaV0Fixture = `{
+		"chirps": {
+			"1": {"id": 1, "body": "first", "author_id": 1},
+			"2": {"id": 2, "body": "second", "author_id": 2},
+			"3": {"id": 3, "body": "third", "author_id": 1}
+		},
+		"users": {
+			"1": {"id": 1, "email": "author@example.com", "hashed_password": "hash"},
+			"2": {"id": 2, "email": "fan@example.com", "hashed_password": "hash"}
+		},
+		"refresh_tokens": {}
+	}`
+	// Sequences, still without timestamps.
+	schemaV1Fixture = `{
+		"schema_version": 1,
+		"chirps": {
+			"1": {"id": 1, "body": "first", "author_id": 1},
+			"2": {"id": 2, "body": "second", "author_id": 2},
+			"3": {"id": 3, "body": "third", "author_id": 1}
+		},
+		"users": {
+			"1": {"id": 1, "email": "author@example.com", "hashed_password": "hash"},
+			"2": {"id": 2, "email": "fan@example.com", "hashed_password": "hash"}
+		},
+		"refresh_tokens": {},
+		"sequences": {"chirps": 3, "users": 2}
+	}`
+	// Follows, but no materialized timelines.
+	schemaV7Fixture = `{
+		"schema_version": 7,
+		"chirps": {
+			"1": {"id": 1, "body": "first", "author_id": 1, "created_at": "2024-01-01T10:00:00Z", "updated_at": "2024-01-01T10:00:00Z"},
+			"2": {"id": 2, "body": "second", "author_id": 2, "created_at": "2024-01-01T11:00:00Z", "updated_at": "2024-01-01T11:00:00Z"},
+			"3": {"id": 3, "body": "third", "author_id": 1, "created_at": "2024-01-01T12:00:00Z", "updated_at": "2024-01-01T12:00:00Z"}
+		},
+		"users": {
+			"1": {"id": 1, "email": "author@example.com", "hashed_password": "hash", "created_at": "2024-01-01T09:00:00Z", "updated_at": "2024-01-01T09:00:00Z"},
+			"2": {"id": 2, "email": "fan@example.com", "hashed_password": "hash", "created_at": "2024-01-01T09:30:00Z", "updated_at": "2024-01-01T09:30:00Z"}
+		},
+		"refresh_tokens": {},
+		"chirp_revisions": {},
+		"likes": {},
+		"follows": {"2": {"1": "2024-01-01T09:45:00Z"}},
+		"sequences": {"chirps": 3, "users": 2}
+	}`
+)
+
+// TestUpgradeFromOlderSchemas loads files written at older schema versions
+// and checks that each gets the upgrades it is missing, in order, and ends
+// up at the current version with the changes those upgrades make.
+func TestUpgradeFromOlderSchemas(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture string
+		// changes is the number of changes each upgrade from the fixture's
+		// version on is expected to report.
+		changes []int
+		// backfilled is whether the fixture's records lack timestamps.
+		backfilled    bool
+		wantTimelines map[int][]int
+	}{
+		{
+			name:    "version 0",
+			fixture: schemaV0Fixture,
+			// Both sequences, five records without timestamps, and the
+			// authors' own chirps in their timelines.
+			changes:       []int{2, 5, 0, 0, 0, 0, 0, 3, 0},
+			backfilled:    true,
+			wantTimelines: map[int][]int{1: {1, 3}, 2: {2}},
+		},
+		{
+			name:          "version 1",
+			fixture:       schemaV1Fixture,
+			changes:       []int{5, 0, 0, 0, 0, 0, 3, 0},
+			backfilled:    true,
+			wantTimelines: map[int][]int{1: {1, 3}, 2: {2}},
+		},
+		{
+			name:    "version 7",
+			fixture: schemaV7Fixture,
+			// The follower's timeline gets the followee's chirps too.
+			changes:       []int{5, 0},
+			wantTimelines: map[int][]int{1: {1, 3}, 2: {1, 2, 3}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "database.json")
+			err := os.WriteFile(path, []byte(tt.fixture), 0600)
+			if err != nil {
+				t.Fatalf("writing fixture: %v", err)
+			}
+			db, err := NewDB(path)
+			if err != nil {
+				t.Fatalf("opening fixture: %v", err)
+			}
+			defer db.Close()
+
+			report := db.UpgradeReport()
+			from := currentSchemaVersion - len(tt.changes)
+			if report.FromVersion != from || report.ToVersion != currentSchemaVersion {
+				t.Errorf("upgraded from version %d to %d, want %d to %d", report.FromVersion, report.ToVersion, from, currentSchemaVersion)
+			}
+			if len(report.Steps) != len(tt.changes) {
+				t.Fatalf("got %d upgrade steps, want %d: %+v", len(report.Steps), len(tt.changes), report.Steps)
+			}
+			for i, step := range report.Steps {
+				if step.Version != from+i+1 {
+					t.Errorf("step %d upgraded to version %d, want %d", i, step.Version, from+i+1)
+				}
+				if step.Changes != tt.changes[i] {
+					t.Errorf("upgrade to version %d made %d changes, want %d", step.Version, step.Changes, tt.changes[i])
+				}
+			}
+
+			snapshot, err := db.Snapshot()
+			if err != nil {
+				t.Fatalf("taking snapshot: %v", err)
+			}
+			if snapshot.SchemaVersion != currentSchemaVersion {
+				t.Errorf("snapshot is at version %d, want %d", snapshot.SchemaVersion, currentSchemaVersion)
+			}
+			if snapshot.Sequences != (Sequences{Chirps: 3, Users: 2}) {
+				t.Errorf("got sequences %+v, want the highest IDs in use", snapshot.Sequences)
+			}
+			// Records without timestamps get the epoch rather than the time
+			// of the upgrade; the others keep theirs.
+			for id, chirp := range snapshot.Chirps {
+				if chirp.CreatedAt.Equal(backfilledAt) != tt.backfilled || chirp.UpdatedAt.Equal(backfilledAt) != tt.backfilled {
+					t.Errorf("chirp %d has timestamps %v and %v", id, chirp.CreatedAt, chirp.UpdatedAt)
+				}
+			}
+			for id, user := range snapshot.Users {
+				if user.CreatedAt.Equal(backfilledAt) != tt.backfilled || user.UpdatedAt.Equal(backfilledAt) != tt.backfilled {
+					t.Errorf("user %d has timestamps %v and %v", id, user.CreatedAt, user.UpdatedAt)
+				}
+			}
+			if !reflect.DeepEqual(snapshot.Timelines, tt.wantTimelines) {
+				t.Errorf("got timelines %v, want %v", snapshot.Timelines, tt.wantTimelines)
+			}
+			err = snapshot.Validate()
+			if err != nil {
+				t.Errorf("validating upgraded snapshot: %v", err)
+			}
+
+			// The upgraded structure was written back, so the upgrades
+			// don't run again.
+			var written struct {
+				SchemaVersion int `json:"schema_version"`
+			}
+			dat, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("reading %s: %v", path, err)
+			}
+			err = json.Unmarshal(dat, &written)
+			if err != nil {
+				t.Fatalf("decoding %s: %v", path, err)
+			}
+			if written.SchemaVersion != currentSchemaVersion {
+				t.Errorf("file was written at version %d, want %d", written.SchemaVersion, currentSchemaVersion)
+			}
+
+			// New IDs continue after the ones in the fixture.
+			user := mustCreateUser(t, db, "late@example.com")
+			if user.ID != 3 {
+				t.Errorf("user created after upgrading got ID %d, want 3", user.ID)
+			}
+		})
+	}
+}
+
+// TestUpgradeRefusesNewerSchema checks that a file written by a newer
+// version of the program is refused rather than loaded without the parts
+// this one doesn't know.
+func TestUpgradeRefusesNewerSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.json")
+	err := os.WriteFile(path, []byte(`{"schema_version": 1000}`), 0600)
+	if err != nil {
+		t.Fatalf("writing fixture: %v", err)
+	}
+	_, err = NewDB(path)
+	if !errors.Is(err, ErrNewerSchema) {
+		t.Errorf("opening a newer schema: got %v, want ErrNewerSchema", err)
+	}
+}
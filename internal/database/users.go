@@ -2,38 +2,60 @@ package database
 
 import (
 	"errors"
+	"time"
 )
 
 type User struct {
-	ID   int    `json:"id"`
-	Email string `json:"email"`
+	ID             int    `json:"id"`
+	Email          string `json:"email"`
 	HashedPassword string `json:"hashed_password"`
-	IsChirpyRed bool `json:"is_chirpy_red"`
+	IsChirpyRed    bool   `json:"is_chirpy_red"`
+	// CreatedAt and UpdatedAt are in UTC.
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// DeletedAt is set while the user is in the trash. Trashed users can't
+	// be looked up or log in, but keep their email reserved so they can be
+	// restored.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
 var ErrAlreadyExists = errors.New("already exists")
 
-func (db *DB) CreateUser(email string, hashedPassword string) (User, error) {
-	_, err := db.GetUserByEmail(email)
-	if !errors.Is(err, ErrNotExist) {
-		return User{}, ErrAlreadyExists
-	}
-
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return User{}, err
+// ErrUnauthorized is returned when a write is made on behalf of a user who
+// doesn't exist or is in the trash, e.g. with a token issued before they
+// were deleted.
+var ErrUnauthorized = errors.New("user does not exist or is in the trash")
+
+// activeUser returns ErrUnauthorized unless the user exists and is not in
+// the trash.
+func (t *tx) activeUser(id int) error {
+	user, ok := t.data.Users[id]
+	if !ok || user.DeletedAt != nil {
+		return ErrUnauthorized
 	}
+	return nil
+}
 
-	id := len(dbStructure.Users) + 1
-	user := User{
-		ID:   id,
-		Email: email,
-		HashedPassword: hashedPassword,
-		IsChirpyRed: false,
-	}
-	dbStructure.Users[id] = user
+func (db *DB) CreateUser(email string, hashedPassword string) (User, error) {
+	user := User{}
+	err := db.update(func(t *tx) error {
+		_, ok := t.idx.userByEmail[normalizeEmail(email)]
+		if ok {
+			return ErrAlreadyExists
+		}
 
-	err = db.writeDB(dbStructure)
+		now := time.Now().UTC()
+		user = User{
+			ID:             t.data.nextUserID(),
+			Email:          email,
+			HashedPassword: hashedPassword,
+			IsChirpyRed:    false,
+			CreatedAt:      now,
+			UpdatedAt:      now,
+		}
+		t.putUser(user)
+		return nil
+	})
 	if err != nil {
 		return User{}, err
 	}
@@ -41,51 +63,65 @@ func (db *DB) CreateUser(email string, hashedPassword string) (User, error) {
 	return user, nil
 }
 
+// GetUserByEmail looks a user up by email, ignoring case.
 func (db *DB) GetUserByEmail(email string) (User, error) {
-	dbStructure, err := db.loadDB()
+	user := User{}
+	err := db.View(func(dbStructure DBStructure) error {
+		id, ok := db.idx.userByEmail[normalizeEmail(email)]
+		if !ok {
+			return ErrNotExist
+		}
+		user = dbStructure.Users[id]
+		if user.DeletedAt != nil {
+			return ErrNotExist
+		}
+		return nil
+	})
 	if err != nil {
 		return User{}, err
 	}
 
-	for _, user := range dbStructure.Users {
-		if user.Email == email {
-			return user, nil
-		}
-	}
-
-	return User{}, ErrNotExist
+	return user, nil
 }
 
 func (db *DB) GetUser(id int) (User, error) {
-	dbStructure, err := db.loadDB()
+	user := User{}
+	err := db.View(func(dbStructure DBStructure) error {
+		var ok bool
+		user, ok = dbStructure.Users[id]
+		if !ok || user.DeletedAt != nil {
+			return ErrNotExist
+		}
+		return nil
+	})
 	if err != nil {
 		return User{}, err
 	}
 
-	user, ok := dbStructure.Users[id]
-	if !ok {
-		return User{}, ErrNotExist
-	}
-
 	return user, nil
 }
 
+// UpdateUser changes a user's email and password. It returns
+// ErrAlreadyExists if the new email belongs to another user.
 func (db *DB) UpdateUser(id int, email, hashedPassword string) (User, error) {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return User{}, err
-	}
-
-	user, ok := dbStructure.Users[id]
-	if !ok {
-		return User{}, ErrNotExist
-	}
-
-	user.Email = email
-	user.HashedPassword = hashedPassword
-	dbStructure.Users[id] = user
+	user := User{}
+	err := db.update(func(t *tx) error {
+		var ok bool
+		user, ok = t.data.Users[id]
+		if !ok || user.DeletedAt != nil {
+			return ErrNotExist
+		}
+		ownerID, ok := t.idx.userByEmail[normalizeEmail(email)]
+		if ok && ownerID != id {
+			return ErrAlreadyExists
+		}
 
-	err = db.writeDB(dbStructure)
+		user.Email = email
+		user.HashedPassword = hashedPassword
+		user.UpdatedAt = time.Now().UTC()
+		t.putUser(user)
+		return nil
+	})
 	if err != nil {
 		return User{}, err
 	}
@@ -93,24 +129,43 @@ func (db *DB) UpdateUser(id int, email, hashedPassword string) (User, error) {
 	return user, nil
 }
 
+// UpdateUserIsChirpyRed upgrades the user to Chirpy Red. Upgrading a user
+// who already has it changes nothing.
 func (db *DB) UpdateUserIsChirpyRed(id int) error {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return err
-	}
-
-	user, ok := dbStructure.Users[id]
-	if !ok {
-		return ErrNotExist
-	}
+	return db.update(func(t *tx) error {
+		user, ok := t.data.Users[id]
+		if !ok || user.DeletedAt != nil {
+			return ErrNotExist
+		}
+		if user.IsChirpyRed {
+			return nil
+		}
 
-	user.IsChirpyRed = true
-	dbStructure.Users[id] = user
+		user.IsChirpyRed = true
+		user.UpdatedAt = time.Now().UTC()
+		t.putUser(user)
+		return nil
+	})
+}
 
-	err = db.writeDB(dbStructure)
-	if err != nil {
-		return err
-	}
+// DeleteUser moves the user to the trash, from which RestoreUser can bring
+// them back until PurgeTrash removes them for good. Their chirps are left
+// as they are, and their refresh tokens are revoked.
+func (db *DB) DeleteUser(id int) error {
+	return db.update(func(t *tx) error {
+		user, ok := t.data.Users[id]
+		if !ok || user.DeletedAt != nil {
+			return ErrNotExist
+		}
 
-	return nil
+		now := time.Now().UTC()
+		user.DeletedAt = &now
+		t.putUser(user)
+		for token, refreshToken := range t.data.RefreshTokens {
+			if refreshToken.UserID == id {
+				t.deleteRefreshToken(token)
+			}
+		}
+		return nil
+	})
 }
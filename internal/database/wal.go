@@ -0,0 +1,254 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// The write-ahead log lives next to the snapshot as path.wal and holds one
+// JSON-encoded walEntry per line for every mutation since the snapshot was
+// written; with encryption on, each line is the base64 of the encrypted
+// entry. Loading replays it over the snapshot; compaction writes a new
+// snapshot and empties it.
+//
+// The log starts with a mark naming the snapshot it extends by the SHA-256
+// of the snapshot file, and another mark is appended just before a new
+// snapshot is written, and taken back if it can't be. Loading requires the
+// last mark to be that of the snapshot it loaded and replays the entries
+// after it, so a log is never replayed over a different snapshot, such as
+// an older generation loaded in place of a damaged primary file, and
+// entries a snapshot already holds aren't replayed over it if a crash kept
+// the log from being emptied.
+
+// ErrWALMismatch is returned when the write-ahead log doesn't extend the
+// snapshot that was loaded, so replaying it would produce inconsistent
+// data.
+var ErrWALMismatch = errors.New("write-ahead log doesn't match the snapshot")
+
+func walPath(path string) string {
+	return path + ".wal"
+}
+
+// openWAL opens the log for appending, creating it if needed, and returns
+// the entries it holds. A torn last line, left by a crash in the middle of
+// an append, is dropped and cut off the file; a bad line anywhere else is
+// reported as an error.
+func openWAL(path string, c *Cipher) (*os.File, int64, []walEntry, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	entries, size, err := readWAL(f, c)
+	if err != nil {
+		f.Close()
+		return nil, 0, nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, nil, err
+	}
+	if info.Size() > size {
+		log.Printf("Dropping torn last entry of %s", path)
+		err := f.Truncate(size)
+		if err != nil {
+			f.Close()
+			return nil, 0, nil, err
+		}
+	}
+	return f, size, entries, nil
+}
+
+// readWALFile returns the entries in the log at path without changing it,
+// for read-only opens. A missing log holds no entries.
+func readWALFile(path string, c *Cipher) ([]walEntry, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries, _, err := readWAL(f, c)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return entries, nil
+}
+
+// readWAL decodes the entries in r and returns them along with the length
+// of the valid prefix of the log.
+func readWAL(r io.Reader, c *Cipher) ([]walEntry, int64, error) {
+	entries := []walEntry{}
+	reader := bufio.NewReader(r)
+	var size int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// A final line without a newline was never completely
+			// written; leave it out of the valid prefix.
+			return entries, size, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		entry, decodeErr := decodeWALEntry(c, line)
+		if decodeErr != nil {
+			// A complete last line that doesn't decode is treated as
+			// torn too, unless the key is at fault.
+			_, peekErr := reader.Peek(1)
+			keyErr := errors.Is(decodeErr, ErrWrongKey) || errors.Is(decodeErr, ErrNoKey)
+			if errors.Is(peekErr, io.EOF) && !keyErr {
+				return entries, size, nil
+			}
+			return nil, 0, fmt.Errorf("entry at offset %d: %w", size, decodeErr)
+		}
+		entries = append(entries, entry)
+		size += int64(len(line))
+	}
+}
+
+func decodeWALEntry(c *Cipher, line []byte) (walEntry, error) {
+	entry := walEntry{}
+	dat, err := decodeWALLine(c, bytes.TrimSuffix(line, []byte("\n")))
+	if err != nil {
+		return entry, err
+	}
+	err = json.Unmarshal(dat, &entry)
+	if err != nil {
+		return entry, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	return entry, nil
+}
+
+// appendWAL writes entries to the log and fsyncs it. On failure the log is
+// cut back to its previous length so a partial write can't leave a torn
+// line in the middle of it. The caller must hold db.flushMu.
+func (db *DB) appendWAL(entries []walEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	buf := bytes.Buffer{}
+	for _, entry := range entries {
+		dat, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		buf.Write(encodeWALLine(db.cipher, dat))
+		buf.WriteByte('\n')
+	}
+
+	err := db.dataLock.lock(true, db.lockTimeout)
+	if err != nil {
+		return err
+	}
+	defer db.dataLock.unlock()
+
+	_, err = db.wal.Write(buf.Bytes())
+	if err == nil {
+		err = db.wal.Sync()
+	}
+	if err != nil {
+		return errors.Join(err, db.truncateWAL(db.walSize))
+	}
+
+	db.walSize += int64(buf.Len())
+	if db.walSize >= db.compactThreshold {
+		db.requestCompaction()
+	}
+	return nil
+}
+
+// truncateWAL cuts the log back to size, dropping what was appended since.
+// The caller must hold db.flushMu and the data lock.
+func (db *DB) truncateWAL(size int64) error {
+	err := db.wal.Truncate(size)
+	if err != nil {
+		return err
+	}
+	db.walSize = size
+	return db.wal.Sync()
+}
+
+// resetWAL empties the log once its entries are part of the snapshot whose
+// file has checksum sum, and starts it with the mark of that snapshot. The
+// caller must hold db.flushMu and the data lock.
+func (db *DB) resetWAL(sum string) error {
+	err := db.truncateWAL(0)
+	if err != nil {
+		return err
+	}
+
+	dat, err := json.Marshal(snapshotMark(sum))
+	if err != nil {
+		return err
+	}
+	line := append(encodeWALLine(db.cipher, dat), '\n')
+	_, err = db.wal.Write(line)
+	if err == nil {
+		err = db.wal.Sync()
+	}
+	if err != nil {
+		return errors.Join(err, db.truncateWAL(0))
+	}
+	db.walSize = int64(len(line))
+	return nil
+}
+
+// snapshotMark returns the WAL entry marking the snapshot whose file has
+// checksum sum.
+func snapshotMark(sum string) walEntry {
+	return walEntry{Op: walOpSnapshot, Key: sum}
+}
+
+// fileChecksum returns the hex-encoded SHA-256 of a snapshot file's
+// contents, as recorded in the marks of the log.
+func fileChecksum(dat []byte) string {
+	sum := sha256.Sum256(dat)
+	return hex.EncodeToString(sum[:])
+}
+
+// walEntriesSince returns the entries to replay over the snapshot whose
+// file has checksum sum: those after the last mark, without marks. found
+// reports whether the last mark is that snapshot's, and marked whether the
+// log has any marks at all; logs written before marks were recorded have
+// none.
+func walEntriesSince(entries []walEntry, sum string) (since []walEntry, found, marked bool) {
+	since = []walEntry{}
+	for _, entry := range entries {
+		if entry.Op != walOpSnapshot {
+			since = append(since, entry)
+			continue
+		}
+		marked = true
+		found = entry.Key == sum
+		since = since[:0]
+	}
+	return since, found, marked
+}
+
+// walEntriesFor returns the entries of the log to replay over the loaded
+// snapshot, whose file has checksum sum, or ErrWALMismatch if the log
+// extends a different snapshot. An unmarked log extends the primary file,
+// so it is only replayed over a previous generation if it holds no entries.
+func (db *DB) walEntriesFor(sum string, entries []walEntry) ([]walEntry, error) {
+	since, found, marked := walEntriesSince(entries, sum)
+	if found || (!marked && (!db.primaryDamaged || len(since) == 0)) {
+		return since, nil
+	}
+	return nil, fmt.Errorf("%w: %s extends a different snapshot than the one loaded; restore a backup, or move the log aside to accept losing its changes", ErrWALMismatch, walPath(db.path))
+}
@@ -0,0 +1,254 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// crash stops db the way a crash would: the background work ends and the
+// files and locks are released, but nothing pending is written.
+func crash(t *testing.T, db *DB) {
+	t.Helper()
+	db.mu.Lock()
+	db.closed = true
+	db.mu.Unlock()
+	db.feed.close()
+	close(db.stop)
+	db.wg.Wait()
+	err := errors.Join(db.wal.Close(), db.closeLocks())
+	if err != nil {
+		t.Fatalf("releasing database files: %v", err)
+	}
+}
+
+// TestFallbackRefusesWALOfNewerSnapshot checks that when the primary file
+// is damaged, a WAL that extends a newer snapshot than the generation
+// loaded instead is refused rather than replayed over it, and that the
+// generation is used once the WAL is moved aside.
+func TestFallbackRefusesWALOfNewerSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.json")
+	opts := DefaultOptions()
+	opts.Durability = DurabilitySync
+	db, err := NewDBWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	user, err := db.CreateUser("author@example.com", "hash")
+	if err != nil {
+		t.Fatalf("creating user: %v", err)
+	}
+	chirp, err := db.CreateChirp(NewChirp{Body: "hello", AuthorID: user.ID})
+	if err != nil {
+		t.Fatalf("creating chirp: %v", err)
+	}
+	err = db.Compact()
+	if err != nil {
+		t.Fatalf("compacting: %v", err)
+	}
+	err = db.LikeChirp(chirp.ID, user.ID)
+	if err != nil {
+		t.Fatalf("liking chirp: %v", err)
+	}
+	crash(t, db)
+
+	compacted, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading %s: %v", path, err)
+	}
+	damage := func() {
+		t.Helper()
+		err := os.WriteFile(path, []byte("{not json"), 0600)
+		if err != nil {
+			t.Fatalf("damaging %s: %v", path, err)
+		}
+	}
+
+	// The WAL holds the like on top of the compacted snapshot, while the
+	// previous generation predates the user and the chirp.
+	damage()
+	_, err = NewDBWithOptions(path, opts)
+	if !errors.Is(err, ErrWALMismatch) {
+		t.Fatalf("reopening after a crash: got %v, want ErrWALMismatch", err)
+	}
+
+	// After a clean close the WAL holds no entries, but still extends the
+	// snapshot written on close rather than the previous generation.
+	err = os.WriteFile(path, compacted, 0600)
+	if err != nil {
+		t.Fatalf("restoring the compacted snapshot: %v", err)
+	}
+	db, err = NewDBWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("reopening the compacted snapshot: %v", err)
+	}
+	likes, err := db.GetLikes(chirp.ID)
+	if err != nil || len(likes) != 1 {
+		t.Fatalf("getting likes: got %+v, %v, want one", likes, err)
+	}
+	err = db.Close()
+	if err != nil {
+		t.Fatalf("closing database: %v", err)
+	}
+	damage()
+	_, err = NewDBWithOptions(path, opts)
+	if !errors.Is(err, ErrWALMismatch) {
+		t.Fatalf("reopening after a close: got %v, want ErrWALMismatch", err)
+	}
+
+	// Moving the WAL aside accepts the previous generation.
+	err = os.Rename(walPath(path), walPath(path)+".aside")
+	if err != nil {
+		t.Fatalf("moving the WAL aside: %v", err)
+	}
+	db, err = NewDBWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("reopening without the WAL: %v", err)
+	}
+	_, err = db.GetChirp(chirp.ID)
+	if err != nil {
+		t.Errorf("getting chirp from the previous generation: %v", err)
+	}
+	_, err = db.CreateUser("late@example.com", "hash")
+	if err != nil {
+		t.Fatalf("creating user after the fallback: %v", err)
+	}
+	crash(t, db)
+
+	// The fallback replaced the damaged file, so the WAL written since
+	// extends it.
+	db, err = NewDBWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("reopening after the fallback: %v", err)
+	}
+	defer db.Close()
+	_, err = db.GetUserByEmail("late@example.com")
+	if err != nil {
+		t.Errorf("getting user created after the fallback: %v", err)
+	}
+}
+
+// TestTornWALLineIsDropped checks that a WAL whose last line was cut off
+// partway, as by a crash during an append, loses only that entry: every
+// complete entry is replayed and the partial one is cut off the file.
+func TestTornWALLineIsDropped(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.json")
+	opts := DefaultOptions()
+	opts.Durability = DurabilitySync
+	db, err := NewDBWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	user, err := db.CreateUser("author@example.com", "hash")
+	if err != nil {
+		t.Fatalf("creating user: %v", err)
+	}
+	for _, body := range []string{"first", "second"} {
+		_, err := db.CreateChirp(NewChirp{Body: body, AuthorID: user.ID})
+		if err != nil {
+			t.Fatalf("creating chirp: %v", err)
+		}
+	}
+	// Updating the user appends a single entry, the one to tear.
+	_, err = db.UpdateUser(user.ID, "renamed@example.com", "hash")
+	if err != nil {
+		t.Fatalf("updating user: %v", err)
+	}
+	crash(t, db)
+
+	dat, err := os.ReadFile(walPath(path))
+	if err != nil {
+		t.Fatalf("reading WAL: %v", err)
+	}
+	complete := bytes.LastIndexByte(dat[:len(dat)-1], '\n') + 1
+	torn := complete + (len(dat)-complete)/2
+	err = os.Truncate(walPath(path), int64(torn))
+	if err != nil {
+		t.Fatalf("tearing WAL: %v", err)
+	}
+
+	db, err = NewDBWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("reopening database: %v", err)
+	}
+	got, err := db.GetUser(user.ID)
+	if err != nil || got.Email != "author@example.com" {
+		t.Errorf("got user %+v, %v, want the email from before the torn update", got, err)
+	}
+	page, err := db.ListChirps(ChirpQuery{})
+	if err != nil {
+		t.Fatalf("listing chirps: %v", err)
+	}
+	if ids := chirpIDs(page.Chirps); !reflect.DeepEqual(ids, []int{1, 2}) {
+		t.Errorf("got chirps %v, want 1 and 2", ids)
+	}
+	info, err := os.Stat(walPath(path))
+	if err != nil {
+		t.Fatalf("checking WAL: %v", err)
+	}
+	if info.Size() != int64(complete) {
+		t.Errorf("WAL is %d bytes, want the %d of its complete lines", info.Size(), complete)
+	}
+
+	// Appends continue after the complete entries.
+	chirp, err := db.CreateChirp(NewChirp{Body: "third", AuthorID: user.ID})
+	if err != nil {
+		t.Fatalf("creating chirp after the torn write: %v", err)
+	}
+	if chirp.ID != 3 {
+		t.Errorf("chirp after the torn write got ID %d, want 3", chirp.ID)
+	}
+	crash(t, db)
+	db, err = NewDBWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("reopening database again: %v", err)
+	}
+	defer db.Close()
+	page, err = db.ListChirps(ChirpQuery{})
+	if err != nil {
+		t.Fatalf("listing chirps: %v", err)
+	}
+	if ids := chirpIDs(page.Chirps); !reflect.DeepEqual(ids, []int{1, 2, 3}) {
+		t.Errorf("got chirps %v after appending, want 1 to 3", ids)
+	}
+}
+
+// TestDamagedWALLineIsAnError checks that a line that doesn't decode is
+// only dropped as torn when it is the last one; anywhere else it fails
+// the load rather than silently losing the entries after it.
+func TestDamagedWALLineIsAnError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.json")
+	opts := DefaultOptions()
+	opts.Durability = DurabilitySync
+	db, err := NewDBWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	for _, email := range []string{"first@example.com", "second@example.com"} {
+		_, err := db.CreateUser(email, "hash")
+		if err != nil {
+			t.Fatalf("creating user: %v", err)
+		}
+	}
+	crash(t, db)
+
+	dat, err := os.ReadFile(walPath(path))
+	if err != nil {
+		t.Fatalf("reading WAL: %v", err)
+	}
+	lines := bytes.SplitAfter(dat, []byte("\n"))
+	// lines holds the snapshot mark, both users and an empty remainder.
+	lines[1] = []byte("{\"op\":\"put\",\"entity\":\"us\n")
+	err = os.WriteFile(walPath(path), bytes.Join(lines, nil), 0600)
+	if err != nil {
+		t.Fatalf("damaging WAL: %v", err)
+	}
+
+	_, err = NewDBWithOptions(path, opts)
+	if !errors.Is(err, ErrCorrupt) {
+		t.Errorf("opening with a damaged WAL line: got %v, want ErrCorrupt", err)
+	}
+}
@@ -1,10 +1,16 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/ArrayOfLilly/chirpy/internal/database"
 	"github.com/joho/godotenv"
@@ -12,22 +18,93 @@ import (
 
 type apiConfig struct {
 	fileserverHits int
-	DB *database.DB
+	DB database.Store
 	jwtSecret string
 	polkaApiKey string
+	adminApiKey string
+	backups database.Backups
+	// editWindow is how long after posting a chirp can be edited; 0
+	// allows edits at any time.
+	editWindow time.Duration
 }
 
 func main() {
 	const filepathRoot = "."
 	const port = "8080"
 
-	db, err := database.NewDB("database.json")
+	dbg := flag.Bool("debug", false, "Enable debug mode")
+	backend := flag.String("db", "json", "Storage backend: json or sqlite")
+	importPath := flag.String("import", "", "Import a JSON database file into the SQLite database and exit")
+	durability := flag.String("durability", "write-behind", "JSON store durability: write-behind or sync")
+	flushInterval := flag.Duration("flush-interval", database.DefaultOptions().FlushInterval, "How often the JSON store writes pending changes")
+	backupDir := flag.String("backup-dir", "backups", "Directory for database backups")
+	editWindow := flag.Duration("edit-window", time.Hour, "How long after posting a chirp can be edited; 0 allows edits at any time")
+	fanoutThreshold := flag.Int("fanout-threshold", database.DefaultFanoutThreshold, "Followers from which an account's chirps are read into timelines instead of pushed; run rebuild-timelines after changing it")
+	trashRetention := flag.Duration("trash-retention", 30*24*time.Hour, "How long deleted chirps and users stay restorable; 0 keeps them forever")
+	flag.Usage = usage
+	flag.Parse()
+
+	err := godotenv.Load()
+	if err != nil {
+		log.Fatal("Error loading .env file")
+	}
+
+	cipher, err := cipherFromEnv("DB_ENCRYPTION_KEY")
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	dbg := flag.Bool("debug", false, "Enable debug mode")
-	flag.Parse()
+	durabilityMode, err := database.ParseDurability(*durability)
+	if err != nil {
+		log.Fatal(err)
+	}
+	dbOpts := database.DefaultOptions()
+	dbOpts.Durability = durabilityMode
+	dbOpts.FlushInterval = *flushInterval
+	dbOpts.Cipher = cipher
+	dbOpts.ReadOnly = readOnlyCommand(flag.Args())
+
+	if *fanoutThreshold < 0 {
+		log.Fatal("-fanout-threshold must not be negative")
+	}
+
+	db, err := openStore(*backend, dbOpts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	db.SetFanoutThreshold(*fanoutThreshold)
+
+	if *importPath != "" {
+		sqliteDB, ok := db.(*database.SQLiteDB)
+		if !ok {
+			log.Fatal("-import requires -db sqlite")
+		}
+		err := database.ImportJSON(*importPath, dbOpts, sqliteDB)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Imported %s into %s\n", *importPath, sqliteDBPath)
+		db.Close()
+		return
+	}
+
+	backups := database.Backups{
+		Dir:    *backupDir,
+		Cipher: cipher,
+	}
+
+	ran, err := runCommand(db, backups, flag.Args())
+	if ran {
+		closeErr := db.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if closeErr != nil {
+			log.Fatal(closeErr)
+		}
+		return
+	}
+
 	if dbg != nil && *dbg {
 		err := db.ResetDB()
 		if err != nil {
@@ -35,10 +112,6 @@ func main() {
 		}
 	}
 
-	err = godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
-	}
 	jwtSecret := os.Getenv("JWT_SECRET")
 	if jwtSecret == "" {
 		log.Fatal("JWT_SECRET environment variable is not set")
@@ -47,6 +120,8 @@ func main() {
 	if polkaApiKey == "" {
 		log.Fatal("POLKA_API_KEY environment variable is not set")
 	}
+	// Optional: the admin data endpoints stay disabled without it.
+	adminApiKey := os.Getenv("ADMIN_API_KEY")
 
 
 	apiCfg := apiConfig{
@@ -54,6 +129,9 @@ func main() {
 		DB: db,
 		jwtSecret: jwtSecret,
 		polkaApiKey: polkaApiKey,
+		adminApiKey: adminApiKey,
+		backups: backups,
+		editWindow: *editWindow,
 	}
 
 	mux := http.NewServeMux()
@@ -64,8 +142,26 @@ func main() {
 	mux.HandleFunc("GET /admin/metrics", apiCfg.handlerMetrics)
 	mux.HandleFunc("GET /api/reset", apiCfg.handlerReset)
 
+	mux.HandleFunc("GET /admin/backups", apiCfg.middlewareAdminAuth(apiCfg.handlerBackupsList))
+	mux.HandleFunc("POST /admin/backups", apiCfg.middlewareAdminAuth(apiCfg.handlerBackupsCreate))
+	mux.HandleFunc("POST /admin/backups/{name}/restore", apiCfg.middlewareAdminAuth(apiCfg.handlerBackupsRestore))
+
+	mux.HandleFunc("GET /admin/trash", apiCfg.middlewareAdminAuth(apiCfg.handlerTrashList))
+	mux.HandleFunc("POST /admin/trash/chirps/{chirpID}/restore", apiCfg.middlewareAdminAuth(apiCfg.handlerTrashRestoreChirp))
+	mux.HandleFunc("POST /admin/trash/users/{userID}/restore", apiCfg.middlewareAdminAuth(apiCfg.handlerTrashRestoreUser))
+
+	mux.HandleFunc("POST /admin/search/reindex", apiCfg.middlewareAdminAuth(apiCfg.handlerSearchReindex))
+
 	mux.HandleFunc("POST /api/users", apiCfg.handlerUsersCreate)
 	mux.HandleFunc("PUT /api/users", apiCfg.handlerUsersUpdate)
+	mux.HandleFunc("DELETE /api/users", apiCfg.handlerUsersDelete)
+	mux.HandleFunc("GET /api/users/{userID}", apiCfg.handlerUserProfile)
+	mux.HandleFunc("POST /api/users/{userID}/followers", apiCfg.handlerUsersFollow)
+	mux.HandleFunc("DELETE /api/users/{userID}/followers", apiCfg.handlerUsersUnfollow)
+	mux.HandleFunc("GET /api/users/me/mentions", apiCfg.handlerUserMentions)
+	mux.HandleFunc("POST /api/users/me/mentions/read", apiCfg.handlerUserMentionsRead)
+
+	mux.HandleFunc("GET /api/timeline", apiCfg.handlerTimeline)
 
 	mux.HandleFunc("POST /api/login", apiCfg.handlerLogin)
 	mux.HandleFunc("POST /api/refresh", apiCfg.handlerRefresh)
@@ -73,8 +169,16 @@ func main() {
 
 	mux.HandleFunc("POST /api/chirps", apiCfg.handlerChirpsCreate)
 	mux.HandleFunc("GET /api/chirps/", apiCfg.handlerChirpsRetrieve)
+	mux.HandleFunc("GET /api/chirps/search", apiCfg.handlerChirpsSearch)
 	mux.HandleFunc("GET /api/chirps/{chirpID}", apiCfg.handlerChirpGet)
+	mux.HandleFunc("PUT /api/chirps/{chirpID}", apiCfg.handlerChirpsUpdate)
 	mux.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.handlerChirpsDelete)
+	mux.HandleFunc("GET /api/chirps/{chirpID}/revisions", apiCfg.handlerChirpRevisions)
+	mux.HandleFunc("GET /api/chirps/{chirpID}/thread", apiCfg.handlerChirpThread)
+	mux.HandleFunc("GET /api/chirps/{chirpID}/likes", apiCfg.handlerChirpLikes)
+	mux.HandleFunc("POST /api/chirps/{chirpID}/likes", apiCfg.handlerChirpsLike)
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}/likes", apiCfg.handlerChirpsUnlike)
+	mux.HandleFunc("POST /api/chirps/{chirpID}/rechirps", apiCfg.handlerChirpsRechirp)
 
 	mux.HandleFunc("POST /api/polka/webhooks", apiCfg.handlerPolkaSendEvent)
 
@@ -84,7 +188,80 @@ func main() {
 		Handler: mux,
 	}
 
-	log.Printf("Serving files from %s on port: %s\n", filepathRoot, port)
-	log.Fatal(srv.ListenAndServe())
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	purgerDone := make(chan struct{})
+	go func() {
+		defer close(purgerDone)
+		if *trashRetention > 0 {
+			runTrashPurger(ctx, db, *trashRetention)
+		}
+	}()
+	go func() {
+		log.Printf("Serving files from %s on port: %s\n", filepathRoot, port)
+		err := srv.ListenAndServe()
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = srv.Shutdown(shutdownCtx)
+	if err != nil {
+		log.Printf("Couldn't shut down server cleanly: %s", err)
+	}
+	<-purgerDone
+	// Flush pending writes before exiting.
+	err = db.Close()
+	if err != nil {
+		log.Printf("Couldn't close database: %s", err)
+	}
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags] [command]\n\n", os.Args[0])
+	fmt.Fprintln(out, "Without a command the API server is started. Commands:")
+	fmt.Fprintln(out, "  backup             write a backup of the database to -backup-dir (safe while the server runs)")
+	fmt.Fprintln(out, "  backups            list the backups in -backup-dir")
+	fmt.Fprintln(out, "  restore <name>     replace the database with a backup from -backup-dir")
+	fmt.Fprintln(out, "  rotate-key         re-encrypt the database and backups with DB_ENCRYPTION_NEW_KEY")
+	fmt.Fprintln(out, "  migrate [-dry-run] upgrade database.json to the current schema version, or report what would change")
+	fmt.Fprintln(out, "  rebuild-timelines  recompute the materialized home timelines from the follows and chirps")
+	fmt.Fprintln(out, "\nFlags:")
+	flag.PrintDefaults()
+}
+
+// cipherFromEnv returns the database cipher for the key in the named
+// environment variable, or nil if it isn't set.
+func cipherFromEnv(name string) (*database.Cipher, error) {
+	encoded := os.Getenv(name)
+	if encoded == "" {
+		return nil, nil
+	}
+	key, err := database.ParseKey(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return database.NewCipher(key)
+}
+
+const (
+	jsonDBPath   = "database.json"
+	sqliteDBPath = "database.db"
+)
+
+func openStore(backend string, opts database.Options) (database.Store, error) {
+	switch backend {
+	case "json":
+		return database.NewDBWithOptions(jsonDBPath, opts)
+	case "sqlite":
+		return database.NewSQLiteDB(sqliteDBPath)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", backend)
+	}
 }
 
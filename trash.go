@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/ArrayOfLilly/chirpy/internal/database"
+)
+
+// trashPurgeInterval is how often the purger looks for expired trash.
+const trashPurgeInterval = time.Hour
+
+// runTrashPurger permanently removes chirps and users that have been in
+// the trash for longer than retention, once at startup and then every
+// trashPurgeInterval, until ctx is cancelled.
+func runTrashPurger(ctx context.Context, db database.Store, retention time.Duration) {
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+	for {
+		purged, err := db.PurgeTrash(time.Now().Add(-retention))
+		if err != nil {
+			log.Printf("Couldn't purge trash: %s", err)
+		} else if purged > 0 {
+			log.Printf("Purged %d items from the trash", purged)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}